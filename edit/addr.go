@@ -325,82 +325,177 @@ func (l lineAddr) reverse() SimpleAddress {
 }
 
 func (l lineAddr) fwd(from int64, ed *Editor) (addr, error) {
+	lines, size := &ed.buf.lines, ed.buf.size()
 	a := addr{from: from, to: from}
 	if a.to > 0 {
-		for a.to < ed.buf.size() {
-			r, err := ed.buf.rune(a.to - 1)
-			if err != nil {
-				return a, err
-			} else if r == '\n' {
-				break
-			}
-			a.to++
+		// Move to the start of the next line,
+		// unless already at the start of a line.
+		if k := lines.count(a.to-1) + 1; k <= lines.len() {
+			a.to = lines.pos(k) + 1
+		} else {
+			a.to = size
 		}
 		if l.n > 0 {
 			a.from = a.to
 		}
 	}
-	for l.n > 0 && a.to < ed.buf.size() {
-		r, err := ed.buf.rune(a.to)
-		if err != nil {
-			return a, err
-		}
-		a.to++
-		if r == '\n' {
-			l.n--
-			if l.n > 0 {
-				a.from = a.to
-			}
+	if l.n == 0 || a.to == size {
+		if l.n > 1 {
+			return addr{}, errors.New("line address out of range")
 		}
+		return a, nil
 	}
-	if l.n > 1 || l.n == 1 && a.to < ed.buf.size() {
+	k0 := lines.count(a.to)
+	switch k := k0 + l.n; {
+	case k <= lines.len():
+		a.to = lines.pos(k) + 1
+		if l.n > 1 {
+			a.from = lines.pos(k-1) + 1
+		}
+	case k > lines.len()+1:
 		return addr{}, errors.New("line address out of range")
+	default:
+		// The last line, with no terminating newline.
+		a.to = size
+		if lines.len() > k0 {
+			a.from = lines.pos(lines.len()) + 1
+		}
 	}
 	return a, nil
 }
 
 func (l lineAddr) rev(from int64, ed *Editor) (addr, error) {
+	lines, size := &ed.buf.lines, ed.buf.size()
 	a := addr{from: from, to: from}
-	if a.from < ed.buf.size() {
-		for a.from > 0 {
-			r, err := ed.buf.rune(a.from - 1)
-			if err != nil {
-				return a, err
-			} else if r == '\n' {
-				break
-			}
-			a.from--
-		}
+	if a.from < size {
+		a.from = lines.lineStart(a.from)
 		a.to = a.from
 	}
-	for l.n > 0 && a.from > 0 {
-		r, err := ed.buf.rune(a.from - 1)
-		if err != nil {
-			return a, err
-		}
-		a.from--
-		if r == '\n' {
-			l.n--
-			a.to = a.from + 1
-		} else if a.from == 0 {
-			a.to = a.from
+	if l.n == 0 || a.from == 0 {
+		if l.n > 1 {
+			return addr{}, errors.New("line address out of range")
 		}
+		a.from = lines.lineStart(a.from)
+		return a, nil
 	}
-	if l.n > 1 {
+	switch c := lines.count(a.from); {
+	case l.n <= c:
+		q := lines.pos(c - l.n + 1)
+		a.from, a.to = lines.lineStart(q), q+1
+	case l.n > c+1:
 		return addr{}, errors.New("line address out of range")
-	}
-	for a.from > 0 {
-		r, err := ed.buf.rune(a.from - 1)
-		if err != nil {
-			return a, err
-		} else if r == '\n' {
-			break
+	default:
+		// Back to the start of the buffer.
+		a.from, a.to = 0, 0
+		if c > 0 && lines.pos(1) == 0 {
+			a.to = 1
 		}
-		a.from--
 	}
 	return a, nil
 }
 
+type lineColAddr struct {
+	line lineAddr
+	col  int
+}
+
+// LineColumn returns the address of the empty string
+// before the cth byte of the UTF-8 encoding of the nth full line.
+// Columns are 1 based, and a column one past the last byte of the line,
+// not counting a terminating newline, is the end of the line.
+// It is an error if column c is not at the boundary of a rune.
+// If n is negative, this is equivalent to the compound address -n:c.
+func LineColumn(n, c int) SimpleAddress {
+	if n < 0 {
+		return simpleAddr{lineColAddr{line: lineAddr{neg: true, n: -n}, col: c}}
+	}
+	return simpleAddr{lineColAddr{line: lineAddr{n: n}, col: c}}
+}
+
+func (l lineColAddr) String() string { return l.line.String() + ":" + strconv.Itoa(l.col) }
+
+func (l lineColAddr) whereFrom(from int64, ed *Editor) (addr, error) {
+	a, err := l.line.whereFrom(from, ed)
+	if err != nil {
+		return addr{}, err
+	}
+	end := a.to
+	if lines := &ed.buf.lines; end > a.from && lines.count(end) > lines.count(end-1) {
+		end-- // Don't count the terminating newline.
+	}
+	rs := ed.buf.runes
+	b0, err := rs.ByteOffset(a.from)
+	if err != nil {
+		return addr{}, err
+	}
+	bEnd, err := rs.ByteOffset(end)
+	if err != nil {
+		return addr{}, err
+	}
+	b := b0 + int64(l.col) - 1
+	if l.col < 1 || b > bEnd {
+		return addr{}, errors.New("column address out of range")
+	}
+	p, err := rs.RuneOffset(b)
+	if err != nil {
+		return addr{}, err
+	}
+	if q, err := rs.ByteOffset(p); err != nil {
+		return addr{}, err
+	} else if q != b {
+		return addr{}, errors.New("column address not at a rune boundary")
+	}
+	return addr{from: p, to: p}, nil
+}
+
+func (l lineColAddr) reverse() SimpleAddress {
+	l.line.neg = !l.line.neg
+	return simpleAddr{l}
+}
+
+type byteAddr int64
+
+// Byte returns the address of the empty string after byte n
+// of the UTF-8 encoding of the buffer.
+// It is an error if byte n is not at the boundary of a rune.
+// If n is negative, this is equivalent to the compound address -#bn.
+func Byte(n int64) SimpleAddress { return simpleAddr{byteAddr(n)} }
+
+func (n byteAddr) String() string {
+	if n < 0 {
+		return "-#b" + strconv.FormatInt(int64(-n), 10)
+	}
+	return "#b" + strconv.FormatInt(int64(n), 10)
+}
+
+func (n byteAddr) whereFrom(from int64, ed *Editor) (addr, error) {
+	rs := ed.buf.runes
+	b0, err := rs.ByteOffset(from)
+	if err != nil {
+		return addr{}, err
+	}
+	size, err := rs.ByteOffset(rs.Size())
+	if err != nil {
+		return addr{}, err
+	}
+	b := b0 + int64(n)
+	if b < 0 || b > size {
+		return addr{}, errors.New("byte address out of range")
+	}
+	p, err := rs.RuneOffset(b)
+	if err != nil {
+		return addr{}, err
+	}
+	if q, err := rs.ByteOffset(p); err != nil {
+		return addr{}, err
+	} else if q != b {
+		return addr{}, errors.New("byte address not at a rune boundary")
+	}
+	return addr{from: p, to: p}, nil
+}
+
+func (n byteAddr) reverse() SimpleAddress { return simpleAddr{byteAddr(-n)} }
+
 // ErrNoMatch is returned when a regular expression address fails to match.
 var ErrNoMatch = errors.New("no match")
 
@@ -442,6 +537,7 @@ func (r reAddr) String() string { return r.re }
 
 type forward struct {
 	*runes.Buffer
+	it  *runes.Iterator
 	err error
 }
 
@@ -449,7 +545,7 @@ func (rs *forward) Rune(i int64) rune {
 	if rs.err != nil {
 		return -1
 	}
-	r, err := rs.Buffer.Rune(i)
+	r, err := rs.it.Rune(i)
 	if err != nil {
 		rs.err = err
 		return -1
@@ -457,10 +553,35 @@ func (rs *forward) Rune(i int64) rune {
 	return r
 }
 
-type reverse struct{ *forward }
+// Reverse is the runes of a Buffer in reverse order.
+// Runes are read a block at a time with a runes.ReverseReader,
+// and the reversed runes are kept in buf.
+type reverse struct {
+	*forward
+	r runes.Reader
+	// Buf holds the reversed runes
+	// beginning at offset q0 of the reverse order.
+	buf []rune
+	q0  int64
+}
 
 func (rs *reverse) Rune(i int64) rune {
-	return rs.forward.Rune(rs.Size() - i - 1)
+	if rs.err != nil {
+		return -1
+	}
+	if i < rs.q0 || i >= rs.q0+int64(len(rs.buf)) {
+		if i != rs.q0+int64(len(rs.buf)) || rs.r == nil {
+			// Not the next rune; begin reading at i.
+			rs.r = rs.ReverseReader(rs.Size() - i)
+		}
+		n, err := rs.r.Read(rs.buf[:cap(rs.buf)])
+		if err != nil {
+			rs.err = err
+			return -1
+		}
+		rs.buf, rs.q0 = rs.buf[:n], i
+	}
+	return rs.buf[i-rs.q0]
 }
 
 func (r reAddr) whereFrom(from int64, ed *Editor) (a addr, err error) {
@@ -468,10 +589,10 @@ func (r reAddr) whereFrom(from int64, ed *Editor) (a addr, err error) {
 	if err != nil {
 		return a, err
 	}
-	fwd := &forward{Buffer: ed.buf.runes}
+	fwd := &forward{Buffer: ed.buf.runes, it: ed.buf.runes.Iterator(0)}
 	rs := re1.Runes(fwd)
 	if r.rev {
-		rs = &reverse{fwd}
+		rs = &reverse{forward: fwd, buf: make([]rune, 0, runes.MinRead)}
 		from = rs.Size() - from
 	}
 	switch match := re.Match(rs, from); {
@@ -505,7 +626,7 @@ const (
 //
 // The address syntax for address a0 is:
 //	a0:	{a0} ',' {a0} | {a0} ';' {a0} | {a0} '+' {a1} | {a0} '-' {a1} | a0 a1 | a1
-//	a1:	'$' | '.'| '\'' l | '#'{n} | n | '/' regexp {'/'} | '?' regexp {'?'}
+//	a1:	'$' | '.'| '\'' l | '#'{n} | '#b'{n} | n | n ':' n | '/' regexp {'/'} | '?' regexp {'?'}
 //	n:	[0-9]+
 //	l:	[a-z]
 //	regexp:	<a valid re1 regular expression>
@@ -517,7 +638,11 @@ const (
 //	. is the current address of the editor, called dot.
 //	'l is the address of the mark named l, where l is a lower-case or upper-case letter: [a-zA-Z.]
 //	#{n} is the empty string after rune number n. If n is missing then 1 is used.
+//	#b{n} is the empty string after byte number n of the UTF-8 encoded buffer.
+//		If n is missing then 1 is used.
 //	n is the nth line in the buffer. 0 is the string before the first full line.
+//	n ':' m is the empty string before the mth byte of the UTF-8 encoding of line n.
+//		Columns are 1 based.
 //	'/' regexp {'/'} is the first match of the regular expression.
 //	'?' regexp {'?'} is the first match of the regular expression going in reverse.
 //
@@ -667,15 +792,22 @@ func parseRuneAddr(rs []rune) (SimpleAddress, []rune, error) {
 	if rs[0] != '#' {
 		panic("not a rune address")
 	}
+	n0 := 1
+	if len(rs) > 1 && rs[1] == 'b' {
+		n0 = 2
+	}
 	var n int
-	for n = 1; n < len(rs) && strings.ContainsRune(digits, rs[n]); n++ {
+	for n = n0; n < len(rs) && strings.ContainsRune(digits, rs[n]); n++ {
 	}
 	s := "1"
-	if n > 1 {
-		s = string(rs[1:n])
+	if n > n0 {
+		s = string(rs[n0:n])
 	}
 	const base, bits = 10, 64
 	r, err := strconv.ParseInt(s, base, bits)
+	if n0 == 2 {
+		return Byte(r), rs[n:], err
+	}
 	return Rune(r), rs[n:], err
 }
 
@@ -687,5 +819,12 @@ func parseLineAddr(rs []rune) (SimpleAddress, []rune, error) {
 	for n = 1; n < len(rs) && strings.ContainsRune(digits, rs[n]); n++ {
 	}
 	l, err := strconv.Atoi(string(rs[:n]))
-	return Line(l), rs[n:], err
+	if err != nil || n+1 >= len(rs) || rs[n] != ':' || !strings.ContainsRune(digits, rs[n+1]) {
+		return Line(l), rs[n:], err
+	}
+	m := n + 1
+	for n = m; n < len(rs) && strings.ContainsRune(digits, rs[n]); n++ {
+	}
+	c, err := strconv.Atoi(string(rs[m:n]))
+	return LineColumn(l, c), rs[n:], err
 }
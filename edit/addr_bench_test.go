@@ -28,7 +28,7 @@ func makeEditor(n int) (*Editor, int) {
 		}
 	}
 	ed := NewEditor(NewBuffer())
-	if err := ed.buf.runes.Insert(rs, 0); err != nil {
+	if err := ed.change(All, string(rs)); err != nil {
 		panic(err)
 	}
 	return ed, lines
@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"unicode/utf8"
 
@@ -74,6 +75,63 @@ func TestRuneAddress(t *testing.T) {
 	}
 }
 
+func TestByteAddress(t *testing.T) {
+	str := "Hello, 世界!"
+	tests := []addressTest{
+		{text: str, addr: Byte(0), want: pt(0)},
+		{text: str, addr: Byte(3), want: pt(3)},
+		{text: str, addr: Byte(7), want: pt(7)},
+		{text: str, addr: Byte(10), want: pt(8)},
+		{text: str, addr: Byte(14), want: pt(10)},
+		{text: str, addr: Byte(8), err: "rune boundary"},
+		{text: str, addr: Byte(15), err: "out of range"},
+
+		{text: str, dot: pt(10), addr: Byte(0), want: pt(10)},
+		{text: str, dot: pt(10), addr: Byte(-1), want: pt(9)},
+		{text: str, dot: pt(10), addr: Byte(-4), want: pt(8)},
+		{text: str, dot: pt(10), addr: Byte(-14), want: pt(0)},
+		{text: str, dot: pt(10), addr: Byte(-2), err: "rune boundary"},
+		{text: str, dot: pt(10), addr: Byte(-15), err: "out of range"},
+
+		// Spanning many blocks of the runes.Buffer.
+		{text: strings.Repeat("世", 5000) + "!", addr: Byte(3 * 5000), want: pt(5000)},
+		{text: strings.Repeat("世", 5000) + "!", addr: Byte(3*5000 + 1), want: pt(5001)},
+		{text: strings.Repeat("世", 5000) + "!", addr: Byte(3*4100 + 1), err: "rune boundary"},
+		{text: strings.Repeat("世", 5000) + "!", dot: pt(5001), addr: Byte(-3*4100 - 1), want: pt(900)},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestLineColumnAddress(t *testing.T) {
+	str := "abc\n世界\n\nxyz"
+	tests := []addressTest{
+		{text: str, addr: LineColumn(1, 1), want: pt(0)},
+		{text: str, addr: LineColumn(1, 3), want: pt(2)},
+		{text: str, addr: LineColumn(1, 4), want: pt(3)},
+		{text: str, addr: LineColumn(2, 4), want: pt(5)},
+		{text: str, addr: LineColumn(2, 7), want: pt(6)},
+		{text: str, addr: LineColumn(3, 1), want: pt(7)},
+		{text: str, addr: LineColumn(4, 4), want: pt(11)},
+		{text: str, addr: LineColumn(1, 5), err: "out of range"},
+		{text: str, addr: LineColumn(3, 2), err: "out of range"},
+		{text: str, addr: LineColumn(1, 0), err: "out of range"},
+		{text: str, addr: LineColumn(6, 1), err: "out of range"},
+		{text: str, addr: LineColumn(2, 8), err: "out of range"},
+		{text: str, addr: LineColumn(2, 2), err: "not at a rune boundary"},
+		{text: str, addr: LineColumn(2, 6), err: "not at a rune boundary"},
+
+		{text: str, dot: pt(7), addr: LineColumn(2, 2), want: pt(9)},
+		{text: str, dot: pt(7), addr: LineColumn(-1, 4), want: pt(5)},
+		{text: str, dot: pt(7), addr: LineColumn(-1, 3), err: "not at a rune boundary"},
+		{text: str, dot: pt(7), addr: LineColumn(-2, 3), want: pt(2)},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
 func TestLineAddress(t *testing.T) {
 	tests := []addressTest{
 		{text: "", addr: Line(0), want: pt(0)},
@@ -144,6 +202,12 @@ func TestRegexpAddress(t *testing.T) {
 		{text: "Hello, 世界!", dot: pt(10), addr: Regexp("?H"), want: rng(0, 1)},
 		{text: "Hello, 世界!", dot: pt(10), addr: Regexp("?[^!]+"), want: rng(0, 9)},
 
+		// Spanning many blocks of the runes.Buffer.
+		{text: "xyz" + strings.Repeat("a", 10000) + "b" + strings.Repeat("世", 10000), dot: pt(20004), addr: Regexp("?xyz"), want: rng(0, 3)},
+		{text: "xyz" + strings.Repeat("a", 10000) + "b" + strings.Repeat("世", 10000), dot: pt(20004), addr: Regexp("?ab世"), want: rng(10002, 10005)},
+		{text: "xyz" + strings.Repeat("a", 10000) + "b" + strings.Repeat("世", 10000), dot: pt(20004), addr: Regexp("?za+b"), want: rng(2, 10004)},
+		{text: "xyz" + strings.Repeat("a", 10000) + "b" + strings.Repeat("世", 10000), dot: pt(10003), addr: Regexp("?b"), want: rng(10003, 10004)},
+
 		{text: "Hello, 世界!", dot: pt(10), addr: Regexp("/H").reverse(), want: rng(0, 1)},
 		{text: "Hello, 世界!", addr: Regexp("?H").reverse(), want: rng(0, 1)},
 
@@ -269,8 +333,8 @@ type addressTest struct {
 func (test addressTest) run(t *testing.T) {
 	ed := NewEditor(NewBuffer())
 	defer ed.buf.Close()
-	if err := ed.buf.runes.Insert([]rune(test.text), 0); err != nil {
-		t.Fatalf(`Put("%s")=%v, want nil`, test.text, err)
+	if err := ed.change(All, test.text); err != nil {
+		t.Fatalf(`ed.change(All, "%s")=%v, want nil`, test.text, err)
 	}
 	if test.marks != nil {
 		ed.marks = test.marks
@@ -322,6 +386,20 @@ func TestAddr(t *testing.T) {
 		{a: " 1\t\n\txyz", left: "\txyz", want: Line(1)},
 		{a: strconv.Itoa(math.MaxInt64) + "0", err: "out of range"},
 
+		{a: "#b0", want: Byte(0)},
+		{a: "#b", want: Byte(1)},
+		{a: "#b1024", want: Byte(1024)},
+		{a: "#b1024xyz", left: "xyz", want: Byte(1024)},
+		{a: "#b" + strconv.Itoa(math.MaxInt64) + "0", err: "out of range"},
+
+		{a: "1:1", want: LineColumn(1, 1)},
+		{a: "12:5", want: LineColumn(12, 5)},
+		{a: "12:5xyz", left: "xyz", want: LineColumn(12, 5)},
+		{a: "12:", left: ":", want: Line(12)},
+		{a: "12:x", left: ":x", want: Line(12)},
+		{a: "-12:5", want: Dot.Minus(LineColumn(12, 5))},
+		{a: "12:" + strconv.Itoa(math.MaxInt64) + "0", err: "out of range"},
+
 		{a: "/", want: Regexp("/")},
 		{a: "//", want: Regexp("//")},
 		{a: "?", want: Regexp("?")},
@@ -489,6 +567,12 @@ func TestAddressString(t *testing.T) {
 		{addr: Line(100)},
 		// Line(-100) is the string -100, when parsed, the implicit . is inserted: .-100.
 		{addr: Line(-100), want: Dot.Minus(Line(100))},
+		{addr: Byte(0)},
+		{addr: Byte(100)},
+		{addr: Byte(-100), want: Dot.Minus(Byte(100))},
+		{addr: LineColumn(1, 1)},
+		{addr: LineColumn(100, 5)},
+		{addr: LineColumn(-100, 5), want: Dot.Minus(LineColumn(100, 5))},
 		{addr: Mark('a')},
 		{addr: Mark('z')},
 		{addr: Regexp("/☺☹")},
@@ -521,12 +605,8 @@ func (e *errReaderAt) WriteAt(b []byte, _ int64) (int, error) { return len(b), n
 
 // TestIOErrors tests IO errors when computing addresses.
 func TestIOErrors(t *testing.T) {
-	rs := []rune("Hello,\nWorld!")
+	const str = "Hello,\nWorld!"
 	tests := []string{
-		"1",
-		"#1+1",
-		"$-1",
-		"#3-1",
 		"/World",
 		"?World",
 		".+/World",
@@ -554,8 +634,14 @@ func TestIOErrors(t *testing.T) {
 		ed := NewEditor(newBuffer(r))
 		defer ed.Close()
 
-		if err := ed.buf.runes.Insert(rs, 0); err != nil {
-			t.Fatalf("ed.buf.runes.Insert(%v, 0)=%v, want nil", strconv.Quote(string(rs)), err)
+		// The change reads back its text, which errReaderAt does not store,
+		// so it must stay in the cache until the change is done.
+		r.SetCacheBlocks(len(str))
+		if err := ed.change(All, str); err != nil {
+			t.Fatalf("ed.change(All, %v)=%v, want nil", strconv.Quote(str), err)
+		}
+		if err := r.SetCacheBlocks(1); err != nil {
+			t.Fatalf("r.SetCacheBlocks(1)=%v, want nil", err)
 		}
 
 		// All subsequent reads will be errors.
@@ -565,3 +651,122 @@ func TestIOErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestCorruptBlockErrors tests corrupt blocks when computing addresses.
+func TestCorruptBlockErrors(t *testing.T) {
+	const str = "Hello,\nWorld!"
+	for _, test := range []string{"/World", "?World", "/Hello/,/World"} {
+		addr, left, err := Addr([]rune(test))
+		if err != nil || len(left) != 0 {
+			t.Fatalf("Addr(%q)=%q,{},%v want _,{},nil", test, addr, err)
+		}
+		f := &memReaderAt{}
+		r := runes.NewBufferReaderWriterAt(1, f)
+		ed := NewEditor(newBuffer(r))
+		defer ed.Close()
+
+		if err := ed.change(All, str); err != nil {
+			t.Fatalf("ed.change(All, %v)=%v, want nil", strconv.Quote(str), err)
+		}
+		for i := range f.data {
+			f.data[i] = ^f.data[i]
+		}
+		if a, err := addr.where(ed); err == nil {
+			t.Errorf("Addr(%q).addr()=%v,%v, want addr{},*runes.CorruptBlockError", test, a, err)
+		} else if _, ok := err.(*runes.CorruptBlockError); !ok {
+			t.Errorf("Addr(%q).addr()=%v,%v, want addr{},*runes.CorruptBlockError", test, a, err)
+		}
+	}
+}
+
+// A memReaderAt is an in-memory ReaderWriterAt
+// whose reads return an error once its error is set.
+type memReaderAt struct {
+	data []byte
+	error
+}
+
+func (m *memReaderAt) ReadAt(b []byte, offs int64) (int, error) {
+	if m.error != nil {
+		return 0, m.error
+	}
+	if offs > int64(len(m.data)) {
+		offs = int64(len(m.data))
+	}
+	return copy(b, m.data[offs:]), nil
+}
+
+func (m *memReaderAt) WriteAt(b []byte, offs int64) (int, error) {
+	if n := offs + int64(len(b)); n > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, n-int64(len(m.data)))...)
+	}
+	return copy(m.data[offs:], b), nil
+}
+
+// TestByteAddrFewReads tests that byte addresses
+// only read the blocks at the ends of the conversion,
+// using the byte lengths kept by the runes.Buffer.
+func TestByteAddrFewReads(t *testing.T) {
+	str := strings.Repeat("世", 5000) + "!"
+	tests := []struct {
+		dot  addr
+		a    Address
+		want addr
+	}{
+		{a: Byte(3 * 4000), want: addr{4000, 4000}},
+		{a: Byte(3*5000 + 1), want: addr{5001, 5001}},
+		{dot: addr{5001, 5001}, a: Dot.Minus(Byte(3*4000 + 1)), want: addr{1000, 1000}},
+	}
+	for _, test := range tests {
+		r := runes.NewBufferReaderWriterAt(8, &memReaderAt{})
+		ed := NewEditor(newBuffer(r))
+		defer ed.Close()
+		if err := ed.change(All, str); err != nil {
+			t.Fatalf("ed.change(All, …)=%v, want nil", err)
+		}
+		ed.marks['.'] = test.dot
+		_, m0 := r.CacheStats()
+		if at, err := test.a.where(ed); at != test.want || err != nil {
+			t.Errorf("%v.where(ed)=%v,%v, want %v,nil", test.a, at, err, test.want)
+		}
+		if _, m1 := r.CacheStats(); m1-m0 > 2 {
+			t.Errorf("%v.where(ed) read %d blocks, want <= 2", test.a, m1-m0)
+		}
+	}
+}
+
+// TestLineAddrNoIO tests that line addresses
+// are computed without reading the buffer.
+func TestLineAddrNoIO(t *testing.T) {
+	const str = "Hello,\nWorld!"
+	tests := []struct {
+		a    string
+		want addr
+	}{
+		{a: "1", want: addr{0, 7}},
+		{a: "#1+1", want: addr{7, 13}},
+		{a: "$-1", want: addr{0, 7}},
+		{a: "#3-1", want: addr{0, 0}},
+		{a: "0,2", want: addr{0, 13}},
+	}
+	for _, test := range tests {
+		a, left, err := Addr([]rune(test.a))
+		if err != nil || len(left) != 0 {
+			t.Fatalf("Addr(%q)=%q,{},%v want _,{},nil", test.a, a, err)
+		}
+		f := &memReaderAt{}
+		r := runes.NewBufferReaderWriterAt(1, f)
+		ed := NewEditor(newBuffer(r))
+		defer ed.Close()
+
+		if err := ed.change(All, str); err != nil {
+			t.Fatalf("ed.change(All, %v)=%v, want nil", strconv.Quote(str), err)
+		}
+
+		// All subsequent reads will be errors.
+		f.error = errors.New("read error")
+		if at, err := a.where(ed); at != test.want || err != nil {
+			t.Errorf("Addr(%q).addr()=%v,%v, want %v,nil", test.a, at, err, test.want)
+		}
+	}
+}
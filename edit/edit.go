@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
@@ -248,11 +249,7 @@ func (e where) do(ed *Editor, w io.Writer) (addr, error) {
 		return addr{}, err
 	}
 	if e.line {
-		l0, l1, err := ed.lines(at)
-		if err != nil {
-			return addr{}, err
-		}
-		if l0 == l1 {
+		if l0, l1 := ed.lines(at); l0 == l1 {
 			_, err = fmt.Fprintf(w, "%d", l0)
 		} else {
 			_, err = fmt.Fprintf(w, "%d,%d", l0, l1)
@@ -270,6 +267,30 @@ func (e where) do(ed *Editor, w io.Writer) (addr, error) {
 	return at, err
 }
 
+type undo struct{ n int }
+
+// Undo returns an Edit
+// that undoes the changes made by the n most recent transactions,
+// as if by Editor.Undo.
+func Undo(n int) Edit { return undo{n: n} }
+
+// Redo returns an Edit
+// that redoes the changes undone by the n most recent undos,
+// as if by Editor.Redo.
+func Redo(n int) Edit { return undo{n: -n} }
+
+func (e undo) String() string {
+	if e.n < 0 {
+		return "u-" + strconv.Itoa(-e.n)
+	}
+	return "u" + strconv.Itoa(e.n)
+}
+
+// Undo edits are performed by Editor.Do directly, outside of a transaction.
+func (e undo) do(*Editor, io.Writer) (addr, error) {
+	return addr{}, errors.New("undo cannot be part of another edit")
+}
+
 // Substitute is an Edit that substitutes regular expression matches.
 type Substitute struct {
 	// A is the address in which to search for matches.
@@ -438,8 +459,225 @@ func subExprMatch(ed *Editor, m [][2]int64, i int) ([]rune, error) {
 	return rs, nil
 }
 
+type loop struct {
+	a   Address
+	re  string
+	e   Edit
+	inv bool
+}
+
+// Loop returns an Edit
+// that performs e with dot set to each match
+// of the regular expression within a
+// and sets dot to the modified address a.
+// The regular expression is compiled with re1.Options{Delimited: true}.
+// The changes made by e are all applied together,
+// so addresses within e are evaluated on the unmodified Buffer.
+func Loop(a Address, re string, e Edit) Edit { return newLoop(a, re, e, false) }
+
+// LoopBetween returns an Edit
+// that is like Loop, but performs e with dot set
+// to each string between the matches
+// of the regular expression within a.
+func LoopBetween(a Address, re string, e Edit) Edit { return newLoop(a, re, e, true) }
+
+func newLoop(a Address, re string, e Edit, inv bool) Edit {
+	if len(re) == 0 {
+		re = "/"
+	}
+	return loop{a: a, re: withTrailingDelim(re), e: e, inv: inv}
+}
+
+func (e loop) String() string {
+	c := "x"
+	if e.inv {
+		c = "y"
+	}
+	return e.a.String() + c + e.re + e.e.String()
+}
+
+func (e loop) do(ed *Editor, w io.Writer) (addr, error) {
+	at, err := e.a.where(ed)
+	if err != nil {
+		return addr{}, err
+	}
+	re, err := re1.Compile([]rune(e.re), re1.Options{Delimited: true})
+	if err != nil {
+		return addr{}, err
+	}
+	var ats []addr
+	if e.inv {
+		ats, err = gaps(ed, at, re)
+	} else {
+		ats, err = matches(ed, at, re)
+	}
+	if err != nil {
+		return addr{}, err
+	}
+	dot := ed.marks['.']
+	defer func() { ed.marks['.'] = dot }()
+	for _, a := range ats {
+		ed.marks['.'] = a
+		if _, err := e.e.do(ed, w); err != nil {
+			return addr{}, err
+		}
+	}
+	return at, nil
+}
+
+// Matches returns the addresses of all matches
+// of the regular expression within at.
+// An empty match adjacent to the previous match is skipped.
+func matches(ed *Editor, at addr, re *re1.Regexp) ([]addr, error) {
+	var ats []addr
+	prev := int64(-1)
+	for from := at.from; from <= at.to; {
+		m, err := match(ed, addr{from, at.to}, re)
+		if err != nil || m == nil {
+			return ats, err
+		}
+		a := addr{m[0][0], m[0][1]}
+		if a.size() == 0 {
+			if a.from == prev {
+				from++
+				continue
+			}
+			from = a.to + 1
+		} else {
+			from = a.to
+		}
+		prev = a.to
+		ats = append(ats, a)
+	}
+	return ats, nil
+}
+
+// Gaps returns the addresses of the strings
+// before, between, and after all matches
+// of the regular expression within at.
+func gaps(ed *Editor, at addr, re *re1.Regexp) ([]addr, error) {
+	ms, err := matches(ed, at, re)
+	if err != nil {
+		return nil, err
+	}
+	var ats []addr
+	from := at.from
+	for _, m := range ms {
+		ats = append(ats, addr{from, m.from})
+		from = m.to
+	}
+	return append(ats, addr{from, at.to}), nil
+}
+
+type guard struct {
+	a   Address
+	re  string
+	e   Edit
+	inv bool
+}
+
+// If returns an Edit
+// that performs e with dot set to a
+// if a contains a match of the regular expression,
+// and sets dot to the modified address a.
+// The regular expression is compiled with re1.Options{Delimited: true}.
+func If(a Address, re string, e Edit) Edit { return newGuard(a, re, e, false) }
+
+// IfNot returns an Edit
+// that is like If, but performs e
+// if a does not contain a match of the regular expression.
+func IfNot(a Address, re string, e Edit) Edit { return newGuard(a, re, e, true) }
+
+func newGuard(a Address, re string, e Edit, inv bool) Edit {
+	if len(re) == 0 {
+		re = "/"
+	}
+	return guard{a: a, re: withTrailingDelim(re), e: e, inv: inv}
+}
+
+func (e guard) String() string {
+	c := "g"
+	if e.inv {
+		c = "v"
+	}
+	return e.a.String() + c + e.re + e.e.String()
+}
+
+func (e guard) do(ed *Editor, w io.Writer) (addr, error) {
+	at, err := e.a.where(ed)
+	if err != nil {
+		return addr{}, err
+	}
+	re, err := re1.Compile([]rune(e.re), re1.Options{Delimited: true})
+	if err != nil {
+		return addr{}, err
+	}
+	m, err := match(ed, at, re)
+	if err != nil {
+		return addr{}, err
+	}
+	if (m != nil) == e.inv {
+		return at, nil
+	}
+	dot := ed.marks['.']
+	defer func() { ed.marks['.'] = dot }()
+	ed.marks['.'] = at
+	if _, err := e.e.do(ed, w); err != nil {
+		return addr{}, err
+	}
+	return at, nil
+}
+
+type block struct {
+	a  Address
+	es []Edit
+}
+
+// Block returns an Edit
+// that performs each of the edits with dot set to a,
+// and sets dot to the modified address a.
+// The edits are performed as a single transaction:
+// all addresses are evaluated on the unmodified Buffer,
+// and the changes of all edits are applied together.
+// The changes may be made in any order,
+// but it is an error if they overlap.
+// Changes overlap if they change the same runes,
+// or if they change the same address,
+// unless both are inserts at the same point.
+func Block(a Address, es ...Edit) Edit { return block{a: a, es: es} }
+
+func (e block) String() string {
+	s := e.a.String() + "{\n"
+	for _, e := range e.es {
+		s += e.String() + "\n"
+	}
+	return s + "}"
+}
+
+func (e block) do(ed *Editor, w io.Writer) (addr, error) {
+	at, err := e.a.where(ed)
+	if err != nil {
+		return addr{}, err
+	}
+	dot := ed.marks['.']
+	defer func() { ed.marks['.'] = dot }()
+	last := logLast(ed.pending)
+	for _, e := range e.es {
+		ed.marks['.'] = at
+		if _, err := e.do(ed, w); err != nil {
+			return addr{}, err
+		}
+	}
+	// The edits may change the text in any order,
+	// so long as the changes do not overlap.
+	if err := ed.pending.sort(last.next()); err != nil {
+		return addr{}, err
+	}
+	return at, nil
+}
+
 type runeSlice struct {
-	buf *runes.Buffer
+	it *runes.Iterator
 	addr
 	err error
 }
@@ -453,7 +691,7 @@ func (rs *runeSlice) Rune(i int64) rune {
 	case rs.err != nil:
 		return -1
 	}
-	r, err := rs.buf.Rune(rs.from + i)
+	r, err := rs.it.Rune(rs.from + i)
 	if err != nil {
 		rs.err = err
 		return -1
@@ -464,7 +702,7 @@ func (rs *runeSlice) Rune(i int64) rune {
 // Match returns the results of matching a regular experssion
 // within an address range in an Editor.
 func match(ed *Editor, at addr, re *re1.Regexp) ([][2]int64, error) {
-	rs := &runeSlice{buf: ed.buf.runes, addr: at}
+	rs := &runeSlice{it: ed.buf.runes.Iterator(0), addr: at}
 	m := re.Match(rs, 0)
 	for i := range m {
 		m[i][0] += at.from
@@ -535,6 +773,80 @@ func match(ed *Editor, at addr, re *re1.Regexp) ([][2]int64, error) {
 //		With '#' returns the rune offsets of the address.
 //		If an address is not supplied, dot is used.
 //		Dot is set to the address.
+//	{addr} x/regexp/ edit
+//		Performs the edit with dot set to each match
+//		of the regular expression in the addressed range.
+//		If the edit is not supplied, p is used.
+//		The changes of all iterations are applied together,
+//		and addresses in the edit are evaluated
+//		on the text from before any of the changes.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the modified address.
+//	{addr} y/regexp/ edit
+//		Just like x, but the edit is performed with dot set
+//		to each string between the matches of the regular expression.
+//	{addr} g/regexp/ edit
+//		Performs the edit with dot set to the address
+//		if the addressed range contains a match of the regular expression.
+//		If the edit is not supplied, p is used.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the modified address.
+//	{addr} v/regexp/ edit
+//		Just like g, but the edit is performed if the addressed range
+//		does not contain a match of the regular expression.
+//	{addr} {
+//	edits
+//	}
+//		Performs each of the edits with dot set to the address.
+//		The edits may be separated by whitespace or newlines.
+//		The edits are performed as a single transaction:
+//		addresses in all of the edits are evaluated
+//		on the text from before any of the changes.
+//		The changes may be made in any order,
+//		but it is an error if they overlap.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the modified address.
+//	e {file}
+//		Changes the entire buffer to the contents of the file,
+//		sets the buffer's file name, and marks the buffer as clean.
+//		The buffer's encoding is set to the detected encoding of the file.
+//		The file name is the rest of the line, with surrounding space trimmed.
+//		If a file name is not supplied, the buffer's file name is used.
+//		It is an error to supply an address.
+//		Dot is set to the entire buffer.
+//	{addr} r {file}
+//		Changes the addressed text to the contents of the file.
+//		If a file name is not supplied, the buffer's file name is used.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the address.
+//	{addr} w {file}
+//		Writes the addressed text to the file in the buffer's encoding.
+//		If a file name is not supplied, the buffer's file name is used.
+//		If the buffer has no file name, it is set to the file name.
+//		Writing the entire buffer to its file marks the buffer as clean.
+//		If an address is not supplied, the entire buffer is used.
+//		Dot is set to the address.
+//	{addr} | command
+//		Changes the addressed text to the output of the shell command
+//		run with the addressed text as its standard input.
+//		The command is the rest of the line, with surrounding space trimmed.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the address.
+//	{addr} < command
+//		Just like |, but the command's standard input is empty.
+//	{addr} > command
+//		Runs the shell command with the addressed text as its standard input.
+//		The command's output is returned.
+//		If an address is not supplied, dot is used.
+//		Dot is set to the address.
+//	! command
+//		Runs the shell command.
+//		The command's output is returned.
+//	u{n}
+//		Undoes the changes made by the last n edits.
+//		If n is not supplied, 1 is used.
+//		If n is preceeded by -, the last n undos are redone instead.
+//		Dot and the marks are restored to their values before the change.
 func Ed(e []rune) (Edit, []rune, error) {
 	edit, left, err := ed(e)
 	for len(left) > 0 && unicode.IsSpace(left[0]) {
@@ -548,11 +860,22 @@ func Ed(e []rune) (Edit, []rune, error) {
 }
 
 func ed(e []rune) (edit Edit, left []rune, err error) {
-	a, e, err := addrOrDot(e)
+	a, e, err := parseCompoundAddr(e)
 	switch {
 	case err != nil:
 		return nil, e, err
-	case len(e) == 0 || e[0] == '\n':
+	case len(e) > 0 && e[0] == 'e':
+		if a != nil {
+			return nil, e, errors.New("e does not take an address")
+		}
+	case len(e) > 0 && e[0] == 'w':
+		if a == nil {
+			a = All
+		}
+	case a == nil:
+		a = Dot
+	}
+	if len(e) == 0 || e[0] == '\n' {
 		return Set(a, '.'), e, nil
 	}
 	switch c, e := e[0], e[1:]; c {
@@ -592,6 +915,84 @@ func ed(e []rune) (edit Edit, left []rune, err error) {
 			return Copy(a, a1), e, nil
 		}
 		return Move(a, a1), e, nil
+	case 'x', 'y', 'g', 'v':
+		exp, e, err := parseRegexp(e)
+		if err != nil {
+			return nil, e, err
+		}
+		if len(exp) < 2 || len(exp) == 2 && exp[0] == exp[1] {
+			return nil, e, errors.New("missing pattern")
+		}
+		sub, e, err := subEdit(e)
+		if err != nil {
+			return nil, e, err
+		}
+		switch c {
+		case 'x':
+			return Loop(a, string(exp), sub), e, nil
+		case 'y':
+			return LoopBetween(a, string(exp), sub), e, nil
+		case 'g':
+			return If(a, string(exp), sub), e, nil
+		case 'v':
+			return IfNot(a, string(exp), sub), e, nil
+		}
+		panic("unreachable")
+	case '{':
+		var es []Edit
+		for {
+			for len(e) > 0 && unicode.IsSpace(e[0]) {
+				e = e[1:]
+			}
+			switch {
+			case len(e) == 0:
+				return nil, e, errors.New("missing }")
+			case e[0] == '}':
+				return Block(a, es...), e[1:], nil
+			}
+			var sub Edit
+			if sub, e, err = ed(e); err != nil {
+				return nil, e, err
+			}
+			es = append(es, sub)
+		}
+	case 'e', 'r', 'w':
+		path, e := parseLineArg(e)
+		switch c {
+		case 'e':
+			return LoadFile(path), e, nil
+		case 'r':
+			return ReadFile(a, path), e, nil
+		case 'w':
+			return WriteFile(a, path), e, nil
+		}
+		panic("unreachable")
+	case '|', '<', '>', '!':
+		cmd, e := parseLineArg(e)
+		switch c {
+		case '|':
+			return Pipe(a, cmd), e, nil
+		case '<':
+			return PipeIn(a, cmd), e, nil
+		case '>':
+			return PipeOut(a, cmd), e, nil
+		case '!':
+			return Run(cmd), e, nil
+		}
+		panic("unreachable")
+	case 'u':
+		var redo bool
+		if len(e) > 0 && e[0] == '-' {
+			redo, e = true, e[1:]
+		}
+		n, e, err := parseNumber(e)
+		if err != nil {
+			return nil, e, err
+		}
+		if redo {
+			return Redo(n), e, nil
+		}
+		return Undo(n), e, nil
 	case 's':
 		n, e, err := parseNumber(e)
 		if err != nil {
@@ -623,6 +1024,18 @@ func ed(e []rune) (edit Edit, left []rune, err error) {
 	}
 }
 
+// SubEdit parses and returns an Edit that is the argument of another Edit.
+// If there is no Edit, Print(Dot) is returned.
+func subEdit(e []rune) (Edit, []rune, error) {
+	for len(e) > 0 && unicode.IsSpace(e[0]) && e[0] != '\n' {
+		e = e[1:]
+	}
+	if len(e) == 0 || e[0] == '\n' {
+		return Print(Dot), e, nil
+	}
+	return ed(e)
+}
+
 func addrOrDot(e []rune) (Address, []rune, error) {
 	a, e, err := parseCompoundAddr(e)
 	switch {
@@ -692,6 +1105,17 @@ func parseDelimited(delim rune, e []rune) ([]rune, []rune) {
 	return rs, nil
 }
 
+// ParseLineArg returns the runes up to the next raw newline
+// with leading and trailing space trimmed,
+// and the remaining runes beginning with the newline.
+func parseLineArg(e []rune) (string, []rune) {
+	var i int
+	for i < len(e) && e[i] != '\n' {
+		i++
+	}
+	return strings.TrimSpace(string(e[:i])), e[i:]
+}
+
 func parseMarkRune(e []rune) (rune, []rune, error) {
 	var i int
 	if i < len(e) && isMarkRune(e[i]) {
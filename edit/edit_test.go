@@ -382,6 +382,142 @@ func TestSubstituteEdit(t *testing.T) {
 	}
 }
 
+func TestLoopEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: Loop(All, "/a/", Change(Dot, "b")), want: "", dot: addr{0, 0}},
+		{init: "abcabc", e: Loop(All, "/a/", Change(Dot, "xyz")), want: "xyzbcxyzbc", dot: addr{0, 10}},
+		{init: "abcabc", e: Loop(All, "/b/", Delete(Dot)), want: "acac", dot: addr{0, 4}},
+		{init: "abcabc", e: Loop(All, "/a*/", Insert(Dot, "-")), want: "-ab-c-ab-c-", dot: addr{0, 11}},
+		{init: "abcabc", e: Loop(All, "/x/", Delete(Dot)), want: "abcabc", dot: addr{0, 6}},
+		{init: "abcabc", e: Loop(Line(0), "/a/", Delete(Dot)), want: "abcabc", dot: addr{0, 0}},
+		{init: "abcabc", e: Loop(All, "/bc/", Print(Dot)), want: "abcabc", print: "bcbc", dot: addr{0, 6}},
+		{init: "abcabc", e: Loop(All, "/a/", Append(Dot.Plus(Rune(1)), "!")), want: "ab!cab!c", dot: addr{0, 8}},
+		{
+			init: "a\nb\nc\n",
+			e:    Loop(All, "/.*\\n/", Loop(Dot, "/[ac]/", Change(Dot, "x"))),
+			want: "x\nb\nx\n",
+			dot:  addr{0, 6},
+		},
+		{init: "abcabc", e: Loop(All, "/a/", Move(Dot, End)), err: "sequence"},
+		{init: "abcabc", e: Loop(All, "/a/", Undo(1)), err: "undo"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestLoopBetweenEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: LoopBetween(All, "/a/", Change(Dot, "b")), want: "b", dot: addr{0, 1}},
+		{init: "abcabc", e: LoopBetween(All, "/a/", Change(Dot, "xyz")), want: "xyzaxyzaxyz", dot: addr{0, 11}},
+		{init: "abcabc", e: LoopBetween(All, "/b/", Delete(Dot)), want: "bb", dot: addr{0, 2}},
+		{init: "abcabc", e: LoopBetween(All, "/x/", Delete(Dot)), want: "", dot: addr{0, 0}},
+		{init: "a,b,c", e: LoopBetween(All, "/,/", Print(Dot)), want: "a,b,c", print: "abc", dot: addr{0, 5}},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestIfEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: If(All, "/a/", Change(Dot, "b")), want: "", dot: addr{0, 0}},
+		{init: "abc", e: If(All, "/b/", Change(Dot, "xyz")), want: "xyz", dot: addr{0, 3}},
+		{init: "abc", e: If(All, "/x/", Change(Dot, "xyz")), want: "abc", dot: addr{0, 3}},
+		{init: "abc", e: If(Regexp("/ab/"), "/c/", Delete(Dot)), want: "abc", dot: addr{0, 2}},
+		{init: "abc", e: If(All, "/b/", Print(Dot)), want: "abc", print: "abc", dot: addr{0, 3}},
+		{
+			init: "abc\ndef\nabx\n",
+			e:    Loop(All, "/.*\\n/", If(Dot, "/ab/", Delete(Dot))),
+			want: "def\n",
+			dot:  addr{0, 4},
+		},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestIfNotEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: IfNot(All, "/a/", Change(Dot, "b")), want: "b", dot: addr{0, 1}},
+		{init: "abc", e: IfNot(All, "/b/", Change(Dot, "xyz")), want: "abc", dot: addr{0, 3}},
+		{init: "abc", e: IfNot(All, "/x/", Change(Dot, "xyz")), want: "xyz", dot: addr{0, 3}},
+		{
+			init: "abc\ndef\nabx\n",
+			e:    Loop(All, "/.*\\n/", IfNot(Dot, "/ab/", Delete(Dot))),
+			want: "abc\nabx\n",
+			dot:  addr{0, 8},
+		},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestBlockEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: Block(All), want: "", dot: addr{0, 0}},
+		{init: "abc", e: Block(All, Insert(Dot, "<"), Append(Dot, ">")), want: "<abc>", dot: addr{0, 5}},
+		{
+			init: "abc\ndef\n",
+			e:    Block(All, Delete(Line(1)), Append(Line(2), "ghi\n")),
+			want: "def\nghi\n",
+			dot:  addr{0, 8},
+		},
+		{
+			init: "abc\ndef\n",
+			e:    Block(All, Copy(Line(2), Line(0)), Delete(Line(2))),
+			want: "def\nabc\n",
+			dot:  addr{0, 8},
+		},
+		{
+			init:  "abc",
+			e:     Block(All, Print(Regexp("/b/")), Print(Dot)),
+			want:  "abc",
+			print: "babc",
+			dot:   addr{0, 3},
+		},
+		{
+			init: "abcabc",
+			e:    Loop(All, "/b/", Block(Dot, Insert(Dot, "<"), Append(Dot, ">"))),
+			want: "a<b>ca<b>c",
+			dot:  addr{0, 10},
+		},
+		// Changes may be made in any order.
+		{init: "abc", e: Block(All, Append(Dot, ">"), Insert(Dot, "<")), want: "<abc>", dot: addr{0, 5}},
+		{
+			init: "abc\ndef\nghi\n",
+			e:    Block(All, Change(Line(3), "3\n"), Delete(Line(2)), Change(Line(1), "1\n")),
+			want: "1\n3\n",
+			dot:  addr{0, 4},
+		},
+		{init: "abc", e: Block(All, Change(Regexp("/b/"), "x"), Delete(Dot)), err: "sequence"},
+		{init: "abcdef\nghi\n", e: Block(All, Change(Line(1), "y"), Change(Line(1), "x")), err: "sequence"},
+		{init: "abcdef\nghi\n", e: Block(All, Delete(Line(1)), Change(Line(1), "x")), err: "sequence"},
+		{init: "abcdef\nghi\n", e: Block(All, Delete(Rune(1).To(Rune(3))), Delete(Rune(2).To(Rune(4)))), err: "sequence"},
+		// Inserts may be made at the same point.
+		{init: "abcdef\nghi\n", e: Block(All, Insert(Line(2), "x"), Insert(Line(2), "y")), want: "abcdef\nxyghi\n", dot: addr{0, 13}},
+		{init: "abc", e: Block(All, Delete(Dot), Undo(1)), err: "undo"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestUndoEdit(t *testing.T) {
+	tests := []eTest{
+		{e: Undo(1), want: "", dot: addr{0, 0}},
+		{e: Redo(1), want: "", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Undo(1), want: "", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Undo(0), want: "Hello, 世界!", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Redo(1), want: "Hello, 世界!", dot: addr{0, 0}},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
 type eTest struct {
 	init, want, print, err string
 	e                      Edit
@@ -558,6 +694,52 @@ func TestEd(t *testing.T) {
 		{e: "s1000/a/b", want: Substitute{A: Dot, RE: "/a/", With: "b", From: 1000}},
 		{e: "s 2 /a/b", want: Substitute{A: Dot, RE: "/a/", With: "b", From: 2}},
 		{e: "s 1000 /a/b/g", want: Substitute{A: Dot, RE: "/a/", With: "b", Global: true, From: 1000}},
+		{e: "x/a/", want: Loop(Dot, "/a/", Print(Dot))},
+		{e: "x/a", want: Loop(Dot, "/a/", Print(Dot))},
+		{e: "x/a/\nd", left: "d", want: Loop(Dot, "/a/", Print(Dot))},
+		{e: "x/a/d", want: Loop(Dot, "/a/", Delete(Dot))},
+		{e: "x/a/ d", want: Loop(Dot, "/a/", Delete(Dot))},
+		{e: ",x/a/c/b/", want: Loop(Line(0).To(End), "/a/", Change(Dot, "b"))},
+		{e: "x;a;.+#1c/b/", want: Loop(Dot, ";a;", Change(Dot.Plus(Rune(1)), "b"))},
+		{e: "x/a/x/b/d", want: Loop(Dot, "/a/", Loop(Dot, "/b/", Delete(Dot)))},
+		{e: "x/a/dxyz", left: "xyz", want: Loop(Dot, "/a/", Delete(Dot))},
+		{e: "y/a/d", want: LoopBetween(Dot, "/a/", Delete(Dot))},
+		{e: ",y/a/", want: LoopBetween(Line(0).To(End), "/a/", Print(Dot))},
+		{e: "x", err: "missing pattern"},
+		{e: "x//d", err: "missing pattern"},
+		{e: "y", err: "missing pattern"},
+
+		{e: "g/a/", want: If(Dot, "/a/", Print(Dot))},
+		{e: "g/a", want: If(Dot, "/a/", Print(Dot))},
+		{e: "g/a/d", want: If(Dot, "/a/", Delete(Dot))},
+		{e: "g/a/ d", want: If(Dot, "/a/", Delete(Dot))},
+		{e: ",g/a/c/b/", want: If(Line(0).To(End), "/a/", Change(Dot, "b"))},
+		{e: "x/.*\\n/g/a/d", want: Loop(Dot, "/.*\\n/", If(Dot, "/a/", Delete(Dot)))},
+		{e: "g/a/dxyz", left: "xyz", want: If(Dot, "/a/", Delete(Dot))},
+		{e: "v/a/d", want: IfNot(Dot, "/a/", Delete(Dot))},
+		{e: "v/a/", want: IfNot(Dot, "/a/", Print(Dot))},
+		{e: "g", err: "missing pattern"},
+		{e: "v//d", err: "missing pattern"},
+
+		{e: "{}", want: Block(Dot)},
+		{e: "{\n}", want: Block(Dot)},
+		{e: ",{d}", want: Block(Line(0).To(End), Delete(Dot))},
+		{e: "{ i/</ a/>/ }", want: Block(Dot, Insert(Dot, "<"), Append(Dot, ">"))},
+		{e: "{\ni/<\na/>\n}", want: Block(Dot, Insert(Dot, "<"), Append(Dot, ">"))},
+		{e: "{\na\nabc\n.\n}", want: Block(Dot, Append(Dot, "abc\n"))},
+		{e: "{\n1d\n$a/x/\n}\nxyz", left: "xyz", want: Block(Dot, Delete(Line(1)), Append(End, "x"))},
+		{e: "{ x/a/ { i/</ a/>/ } }", want: Block(Dot, Loop(Dot, "/a/", Block(Dot, Insert(Dot, "<"), Append(Dot, ">"))))},
+		{e: "{", err: "missing }"},
+		{e: "{d", err: "missing }"},
+		{e: "{\nd\n", err: "missing }"},
+
+		{e: "u", want: Undo(1)},
+		{e: "u3", want: Undo(3)},
+		{e: "u 3", want: Undo(3)},
+		{e: "u-", want: Redo(1)},
+		{e: "u-3", want: Redo(3)},
+		{e: "u3xyz", left: "xyz", want: Undo(3)},
+
 		{e: "s/", err: "missing pattern"},
 		{e: "s//b", err: "missing pattern"},
 		{e: "s/\n/b", err: "missing pattern"},
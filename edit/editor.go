@@ -19,23 +19,154 @@ type Buffer struct {
 	runes    *runes.Buffer
 	eds      []*Editor
 	seq, who int32
+	// Undo and redo hold the inverses of changes
+	// that can be undone and redone respectively.
+	undo, redo *history
+	// Name is the name of the file associated with the Buffer.
+	name string
+	// Enc is the encoding of the Buffer's file.
+	enc runes.Encoding
+	// Dirty is whether the Buffer has changed
+	// since it was last read from or written to its file.
+	dirty bool
+	// Clean is the number of groups in the undo history
+	// when the Buffer was last read from or written to its file,
+	// or -1 if that state can no longer be reached by undo and redo.
+	// The Buffer is dirty unless the undo history has clean groups.
+	clean int
+	// Subs are called for each change to the Buffer.
+	subs []*subscriber
+	// Lines indexes the newlines of the Buffer.
+	lines lineIndex
+	// Journal, if non-nil, records each change to the Buffer.
+	journal *journal
 }
 
+// A ChangeEvent describes a change applied to a Buffer.
+type ChangeEvent struct {
+	// From and To are the rune offsets
+	// of the changed string before the change.
+	From, To int64
+	// Size is the number of runes
+	// in the string after the change.
+	Size int64
+	// Seq is the sequence number of the Buffer
+	// at the time of the change.
+	// All changes made by a single Edit have the same Seq.
+	Seq int32
+	// Who is the ID of the Editor that made the change.
+	Who int32
+}
+
+type subscriber struct{ f func(ChangeEvent) }
+
 // NewBuffer returns a new, empty Buffer.
 func NewBuffer() *Buffer {
-	return newBuffer(runes.NewBuffer(1 << 12))
+	rs := runes.NewBuffer(1 << 12)
+	// Cache a few blocks, so that edits like Move and Copy,
+	// which alternate between two regions of the buffer,
+	// don't thrash the backing file.
+	// Nothing is cached yet, so there is nothing to write back.
+	rs.SetCacheBlocks(8)
+	return newBuffer(rs)
 }
 
-func newBuffer(rs *runes.Buffer) *Buffer { return &Buffer{runes: rs} }
+func newBuffer(rs *runes.Buffer) *Buffer {
+	return &Buffer{runes: rs, undo: newHistory(), redo: newHistory()}
+}
 
 // Close closes the Buffer.
 // After Close is called, the Buffer is no longer editable.
 func (buf *Buffer) Close() error {
 	buf.lock.Lock()
 	defer buf.lock.Unlock()
+	if err := buf.undo.close(); err != nil {
+		return err
+	}
+	if err := buf.redo.close(); err != nil {
+		return err
+	}
+	if buf.journal != nil {
+		if err := buf.journal.close(); err != nil {
+			return err
+		}
+	}
 	return buf.runes.Close()
 }
 
+// FileName returns the name of the file associated with the Buffer.
+// If there is no associated file, the empty string is returned.
+func (buf *Buffer) FileName() string {
+	buf.lock.RLock()
+	defer buf.lock.RUnlock()
+	return buf.name
+}
+
+// SetFileName sets the name of the file associated with the Buffer.
+func (buf *Buffer) SetFileName(name string) {
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	buf.name = name
+}
+
+// Encoding returns the encoding in which the Buffer's file is read and written.
+// It is set by loading the file, and it is UTF-8 by default.
+func (buf *Buffer) Encoding() runes.Encoding {
+	buf.lock.RLock()
+	defer buf.lock.RUnlock()
+	return buf.enc
+}
+
+// SetEncoding sets the encoding in which the Buffer's file is written.
+func (buf *Buffer) SetEncoding(enc runes.Encoding) {
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	buf.enc = enc
+}
+
+// SetDirty sets whether the Buffer is dirty
+// from the number of groups in its undo history.
+//
+// This method must be called with the Lock held.
+func (buf *Buffer) setDirty() {
+	buf.dirty = len(buf.undo.marks) != buf.clean
+}
+
+// Dirty returns whether the Buffer has changed
+// since it was last read from or written to its file.
+// A Buffer is not dirty if its changes since then are undone.
+func (buf *Buffer) Dirty() bool {
+	buf.lock.RLock()
+	defer buf.lock.RUnlock()
+	return buf.dirty
+}
+
+// Subscribe registers f to be called with a ChangeEvent
+// for each change applied to the Buffer,
+// including changes made by Undo and Redo,
+// in the order that the changes are applied.
+// It returns a function that cancels the subscription.
+//
+// The function f is called with the Buffer locked,
+// so it must not call methods on the Buffer
+// or any of its Editors, including the cancel function.
+func (buf *Buffer) Subscribe(f func(ChangeEvent)) (cancel func()) {
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	sub := &subscriber{f: f}
+	buf.subs = append(buf.subs, sub)
+	return func() {
+		buf.lock.Lock()
+		defer buf.lock.Unlock()
+		for i := range buf.subs {
+			if buf.subs[i] == sub {
+				buf.subs = append(buf.subs[:i], buf.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
 // Size returns the number of runes in the Buffer.
 //
 // This method must be called with the RLock held.
@@ -47,23 +178,54 @@ func (buf *Buffer) size() int64 { return buf.runes.Size() }
 func (buf *Buffer) rune(i int64) (rune, error) { return buf.runes.Rune(i) }
 
 // Change changes the string identified by at
-// to contain the runes from the Reader.
+// to contain the runes from the Reader,
+// and returns the number of runes read.
+// The inverse of the change is appended to the log h
+// with the given sequence number and Editor identifier.
 //
 // This method must be called with the Lock held.
-func (buf *Buffer) change(at addr, src runes.Reader) error {
+func (buf *Buffer) change(at addr, src runes.Reader, h *log, seq, who int32) (int64, error) {
+	old := runes.LimitReader(buf.runes.Reader(at.from), at.size())
+	if err := h.append(seq, who, at, old); err != nil {
+		return 0, err
+	}
 	if err := buf.runes.Delete(at.size(), at.from); err != nil {
-		return err
+		return 0, err
 	}
 	n, err := runes.Copy(buf.runes.Writer(at.from), src)
 	if err != nil {
-		return err
+		return n, err
+	}
+	nls, err := newlines(buf.runes, at.from, n)
+	if err != nil {
+		return n, err
+	}
+	buf.lines.change(at, n, nls)
+	if buf.journal != nil {
+		data := runes.LimitReader(buf.runes.Reader(at.from), n)
+		if err := buf.journal.append(at, n, seq, who, data); err != nil {
+			return n, err
+		}
+	}
+	// The inverse changes the newly added runes.
+	inv := logLast(h)
+	if inv.err != nil {
+		return n, inv.err
+	}
+	inv.at = addr{from: at.from, to: at.from + n}
+	if err := inv.store(); err != nil {
+		return n, err
 	}
 	for _, ed := range buf.eds {
 		for m := range ed.marks {
 			ed.marks[m] = ed.marks[m].update(at, n)
 		}
 	}
-	return nil
+	ev := ChangeEvent{From: at.from, To: at.to, Size: n, Seq: seq, Who: who}
+	for _, sub := range buf.subs {
+		sub.f(ev)
+	}
+	return n, nil
 }
 
 // An Editor edits a Buffer of runes.
@@ -72,6 +234,36 @@ type Editor struct {
 	who     int32
 	marks   map[rune]addr
 	pending *log
+	// File holds changes to the Buffer's file state
+	// that are applied along with the pending changes.
+	file pendingFile
+}
+
+// A pendingFile holds changes to a Buffer's file state.
+type pendingFile struct {
+	// Name is the new file name of the Buffer.
+	// It is only used if setName is true.
+	name    string
+	setName bool
+	// Enc is the new encoding of the Buffer.
+	// It is only used if setEnc is true.
+	enc    runes.Encoding
+	setEnc bool
+	// Clean is whether the Buffer is no longer dirty
+	// once the pending changes are applied.
+	// It is only used if exactly cleanChanges changes are applied,
+	// because the file does not reflect any other changes.
+	clean        bool
+	cleanChanges int
+	// Writes are the files to write
+	// with text from before the pending changes.
+	writes []pendingWrite
+}
+
+// A pendingWrite is a file write of an address of the Buffer.
+type pendingWrite struct {
+	at   addr
+	path string
 }
 
 // NewEditor returns an Editor that edits the given buffer.
@@ -89,6 +281,11 @@ func NewEditor(buf *Buffer) *Editor {
 	return ed
 }
 
+// ID returns the Editor's identifier,
+// which is unique among the Editors of its Buffer.
+// It is the Who of the ChangeEvents for changes made by the Editor.
+func (ed *Editor) ID() int32 { return ed.who }
+
 // Close closes the editor.
 func (ed *Editor) Close() error {
 	ed.buf.lock.Lock()
@@ -117,6 +314,12 @@ func (ed *Editor) Where(a Address) (addr, error) {
 
 // Do performs an Edit on the Editor's Buffer.
 func (ed *Editor) Do(e Edit, w io.Writer) error {
+	if u, ok := e.(undo); ok {
+		if u.n < 0 {
+			return ed.Redo(-u.n)
+		}
+		return ed.Undo(u.n)
+	}
 	return ed.do(func() (addr, error) { return e.do(ed, w) })
 }
 
@@ -153,7 +356,7 @@ retry:
 	if at, err = fixAddrs(at, ed.pending); err != nil {
 		return err
 	}
-	switch retry, err := applyChanges(ed, seq); {
+	switch retry, err := applyChanges(ed, seq, marks); {
 	case err != nil:
 		return err
 	case retry:
@@ -168,6 +371,7 @@ func pendChanges(ed *Editor, f func() (addr, error)) (int32, addr, error) {
 	if err := ed.pending.clear(); err != nil {
 		return 0, addr{}, err
 	}
+	ed.file = pendingFile{}
 
 	ed.buf.lock.RLock()
 	defer ed.buf.lock.RUnlock()
@@ -176,17 +380,62 @@ func pendChanges(ed *Editor, f func() (addr, error)) (int32, addr, error) {
 	return seq, at, err
 }
 
-func applyChanges(ed *Editor, seq int32) (bool, error) {
+// ApplyChanges applies the Editor's pending changes
+// and records their inverse in the Buffer's undo history
+// along with the Editor's marks from before the changes.
+func applyChanges(ed *Editor, seq int32, marks map[rune]addr) (bool, error) {
 	ed.buf.lock.Lock()
 	defer ed.buf.lock.Unlock()
 	if ed.buf.seq != seq {
 		return true, nil
 	}
+	for _, w := range ed.file.writes {
+		if err := writeFile(ed, w.at, w.path); err != nil {
+			return false, err
+		}
+	}
+	if !logFirst(ed.pending).end() {
+		if err := ed.buf.redo.clear(); err != nil {
+			return false, err
+		}
+		if ed.buf.clean > len(ed.buf.undo.marks) {
+			// The clean state was discarded with the redo history.
+			ed.buf.clean = -1
+		}
+		ed.buf.undo.push(marks)
+	}
+	var n int
 	for e := logFirst(ed.pending); !e.end(); e = e.next() {
-		if err := ed.buf.change(e.at, e.data()); err != nil {
+		if _, err := ed.buf.change(e.at, e.data(), ed.buf.undo.log, seq, ed.who); err != nil {
 			// TODO(eaburns): Very bad; what should we do?
 			return false, err
 		}
+		n++
+	}
+	if ed.file.setName {
+		ed.buf.name = ed.file.name
+	}
+	if ed.file.setEnc {
+		ed.buf.enc = ed.file.enc
+	}
+	clean := ed.file.clean && n == ed.file.cleanChanges
+	if clean {
+		ed.buf.clean = len(ed.buf.undo.marks)
+	}
+	ed.buf.setDirty()
+	if j := ed.buf.journal; j != nil {
+		var err error
+		switch {
+		case clean:
+			// The file has the contents of the Buffer,
+			// so earlier entries are not needed to recover it.
+			err = j.reset(ed.buf, seq, ed.who)
+		case n > 0:
+			err = j.sync()
+		}
+		if err != nil {
+			return false, err
+		}
 	}
 	ed.buf.seq++
 	return false, nil
@@ -217,11 +466,17 @@ func fixAddrs(at addr, l *log) (addr, error) {
 	return at, nil
 }
 
+// InSequence returns whether the changes in the log
+// are in order of their addresses and do not overlap.
 func inSequence(l *log) bool {
 	e := logFirst(l)
 	for !e.end() {
 		f := e.next()
-		if f.at != e.at && f.at.from < e.at.to {
+		if f.end() {
+			break
+		}
+		// Only inserts may be made at the same address.
+		if f.at.from < e.at.to || f.at == e.at && e.at.size() > 0 {
 			return false
 		}
 		e = f
@@ -233,25 +488,13 @@ func pend(ed *Editor, at addr, src runes.Reader) error {
 	return ed.pending.append(ed.buf.seq, ed.who, at, src)
 }
 
-func (ed *Editor) lines(at addr) (l0, l1 int64, err error) {
-	var i int64
-	l0 = int64(1) // line numbers are 1 based.
-	for ; i < at.from; i++ {
-		r, err := ed.buf.rune(i)
-		if err != nil {
-			return 0, 0, err
-		} else if r == '\n' {
-			l0++
-		}
-	}
-	l1 = l0
-	for ; i < at.to; i++ {
-		r, err := ed.buf.rune(i)
-		if err != nil {
-			return 0, 0, err
-		} else if r == '\n' && i < at.to-1 {
-			l1++
-		}
+// Lines returns the line numbers of the first and last lines of the address.
+// Line numbers are 1 based.
+// A newline that ends the address does not begin a new line.
+func (ed *Editor) lines(at addr) (l0, l1 int64) {
+	l0 = int64(ed.buf.lines.count(at.from)) + 1
+	if at.to-1 <= at.from {
+		return l0, l0
 	}
-	return l0, l1, nil
+	return l0, int64(ed.buf.lines.count(at.to-1)) + 1
 }
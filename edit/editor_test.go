@@ -4,6 +4,7 @@ package edit
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 
 	"github.com/eaburns/T/edit/runes"
@@ -19,7 +20,7 @@ func (ed *Editor) String() string {
 }
 
 func (ed *Editor) change(a Address, s string) error {
-	return ed.Do(Change(All, s), bytes.NewBuffer(nil))
+	return ed.Do(Change(a, s), bytes.NewBuffer(nil))
 }
 
 func TestRetry(t *testing.T) {
@@ -70,3 +71,97 @@ func TestWhere(t *testing.T) {
 		}
 	}
 }
+
+func TestUndoRedo(t *testing.T) {
+	ed := NewEditor(NewBuffer())
+	defer ed.buf.Close()
+
+	do := func(e Edit) {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+	check := func(want string, dot addr) {
+		if s := ed.String(); s != want {
+			t.Errorf("ed.String()=%q, want %q", s, want)
+		}
+		if d := ed.marks['.']; d != dot {
+			t.Errorf("ed.marks['.']=%v, want %v", d, dot)
+		}
+	}
+
+	do(Change(All, "Hello, World!"))
+	do(Set(Regexp("/World"), 'a'))
+	do(SubGlobal(All, "/o/", "0"))
+	do(Move(Regexp("/Hell0/"), End))
+	check(", W0rld!Hell0", addr{8, 13})
+
+	if err := ed.Undo(1); err != nil {
+		t.Fatalf("ed.Undo(1)=%v, want nil", err)
+	}
+	check("Hell0, W0rld!", addr{0, 13})
+	if a := ed.marks['a']; a != (addr{7, 12}) {
+		t.Errorf("ed.marks['a']=%v, want %v", a, addr{7, 12})
+	}
+
+	if err := ed.Undo(1); err != nil {
+		t.Fatalf("ed.Undo(1)=%v, want nil", err)
+	}
+	check("Hello, World!", addr{0, 13})
+
+	if err := ed.Redo(2); err != nil {
+		t.Fatalf("ed.Redo(2)=%v, want nil", err)
+	}
+	check(", W0rld!Hell0", addr{8, 13})
+
+	if err := ed.Undo(100); err != nil {
+		t.Fatalf("ed.Undo(100)=%v, want nil", err)
+	}
+	check("", addr{0, 0})
+
+	if err := ed.Redo(1); err != nil {
+		t.Fatalf("ed.Redo(1)=%v, want nil", err)
+	}
+	check("Hello, World!", addr{0, 13})
+
+	// A new change discards the redo history.
+	do(Append(End, "\n"))
+	if err := ed.Redo(1); err != nil {
+		t.Fatalf("ed.Redo(1)=%v, want nil", err)
+	}
+	check("Hello, World!\n", addr{13, 14})
+}
+
+func TestSubscribe(t *testing.T) {
+	buf := NewBuffer()
+	defer buf.Close()
+	ed0 := NewEditor(buf)
+	ed1 := NewEditor(buf)
+	var evs []ChangeEvent
+	cancel := buf.Subscribe(func(ev ChangeEvent) { evs = append(evs, ev) })
+
+	do := func(ed *Editor, e Edit) {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+	do(ed0, Change(All, "Hello, World!"))
+	do(ed1, SubGlobal(All, "/o/", "00"))
+	do(ed1, Print(All))
+	if err := ed0.Undo(1); err != nil {
+		t.Fatalf("ed0.Undo(1)=%v, want nil", err)
+	}
+	cancel()
+	do(ed0, Delete(All))
+
+	want := []ChangeEvent{
+		{From: 0, To: 0, Size: 13, Seq: 0, Who: ed0.ID()},
+		{From: 4, To: 5, Size: 2, Seq: 1, Who: ed1.ID()},
+		{From: 9, To: 10, Size: 2, Seq: 1, Who: ed1.ID()},
+		{From: 9, To: 11, Size: 1, Seq: 3, Who: ed0.ID()},
+		{From: 4, To: 6, Size: 1, Seq: 3, Who: ed0.ID()},
+	}
+	if !reflect.DeepEqual(evs, want) {
+		t.Errorf("events=%+v, want %+v", evs, want)
+	}
+}
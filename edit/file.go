@@ -0,0 +1,197 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+// ErrNoFileName is returned when a file edit has no file name
+// and the Buffer has no associated file.
+var ErrNoFileName = errors.New("no file name")
+
+type file struct {
+	a    Address
+	op   rune
+	path string
+}
+
+// LoadFile returns an Edit
+// that changes the entire Buffer to the contents of the file at path,
+// sets the Buffer's file name to path,
+// sets the Buffer's encoding to the detected encoding of the file,
+// marks the Buffer as clean,
+// and sets dot to the entire Buffer.
+// If path is empty, the Buffer's file name is used.
+func LoadFile(path string) Edit { return file{a: All, op: 'e', path: path} }
+
+// ReadFile returns an Edit
+// that changes the string at a to the contents of the file at path,
+// decoded from its detected encoding,
+// and sets dot to the changed runes.
+// If path is empty, the Buffer's file name is used.
+func ReadFile(a Address, path string) Edit { return file{a: a, op: 'r', path: path} }
+
+// WriteFile returns an Edit
+// that writes the string at a to the file at path
+// in the Buffer's encoding,
+// and sets dot to a.
+// If path is empty, the Buffer's file name is used.
+// If the Buffer has no file name, it is set to path.
+// If a is the entire Buffer and path is the Buffer's file name,
+// the Buffer is marked as clean,
+// unless the Buffer is also changed by the same Edit.
+// The file is not written if the Edit fails.
+func WriteFile(a Address, path string) Edit { return file{a: a, op: 'w', path: path} }
+
+func (e file) String() string {
+	s := string(e.op)
+	if e.op != 'e' {
+		s = e.a.String() + s
+	}
+	if e.path != "" {
+		s += " " + e.path
+	}
+	return s
+}
+
+func (e file) do(ed *Editor, _ io.Writer) (addr, error) {
+	path := e.path
+	if path == "" {
+		path = ed.buf.name
+	}
+	if path == "" {
+		return addr{}, ErrNoFileName
+	}
+	at, err := e.a.where(ed)
+	if err != nil {
+		return addr{}, err
+	}
+	switch e.op {
+	case 'e':
+		enc, err := readFile(ed, at, path)
+		if err != nil {
+			return addr{}, err
+		}
+		// The file's contents are the one pending change.
+		ed.file = pendingFile{name: path, setName: true, enc: enc, setEnc: true, clean: true, cleanChanges: 1, writes: ed.file.writes}
+		return at, nil
+	case 'r':
+		_, err := readFile(ed, at, path)
+		return at, err
+	case 'w':
+		// The file is written when the pending changes are applied,
+		// so that it is not written if the Edit fails or is retried.
+		ed.file.writes = append(ed.file.writes, pendingWrite{at: at, path: path})
+		if ed.buf.name == "" {
+			ed.file.name, ed.file.setName = path, true
+		}
+		if path == ed.buf.name || ed.buf.name == "" {
+			// The file has the text from before any pending changes.
+			ed.file.clean = at == addr{from: 0, to: ed.buf.size()}
+			ed.file.cleanChanges = 0
+		}
+		return at, nil
+	default:
+		panic("bad file edit")
+	}
+}
+
+// DetectBytes is the number of bytes
+// used to detect the encoding of a file.
+const detectBytes = 4096
+
+// ReadFile pends a change of the address to the contents of the file,
+// and returns the file's encoding.
+func readFile(ed *Editor, at addr, path string) (runes.Encoding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var p [detectBytes]byte
+	n, err := io.ReadFull(f, p[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	enc := runes.DetectEncoding(p[:n], n < len(p))
+	if enc != runes.UTF8 {
+		return enc, pend(ed, at, enc.Reader(f))
+	}
+	err = pend(ed, at, runes.StrictUTF8Reader(f))
+	if _, ok := err.(*runes.UTF8Error); !ok {
+		return enc, err
+	}
+	// The file is not UTF-8 after the detected bytes.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if enc, err = detectByteEncoding(f); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return enc, pend(ed, at, enc.Reader(f))
+}
+
+// DetectByteEncoding returns Windows1252
+// if any block of detectBytes bytes read from r
+// is detected as Windows1252, and Latin1 otherwise.
+func detectByteEncoding(r io.Reader) (runes.Encoding, error) {
+	var p [detectBytes]byte
+	for {
+		n, err := io.ReadFull(r, p[:])
+		if n > 0 && runes.DetectEncoding(p[:n], true) == runes.Windows1252 {
+			return runes.Windows1252, nil
+		}
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return runes.Latin1, nil
+		case err != nil:
+			return 0, err
+		}
+	}
+}
+
+// WriteFile writes the address to the file in the Buffer's encoding.
+//
+// This function must be called with the Lock held.
+func writeFile(ed *Editor, at addr, path string) error {
+	enc := ed.buf.enc
+	if enc != runes.UTF8 {
+		// Refuse to overwrite the file with text that can't be encoded.
+		// Reading the text also checks its blocks for corruption.
+		r := runes.LimitReader(ed.buf.runes.Reader(at.from), at.size())
+		if _, err := runes.Copy(enc.Writer(ioutil.Discard), r); err != nil {
+			return err
+		}
+	} else if err := ed.buf.runes.VerifyRange(at.size(), at.from); err != nil {
+		// Refuse to overwrite the file with corrupted text.
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	r := runes.LimitReader(ed.buf.runes.Reader(at.from), at.size())
+	if _, err := runes.Copy(enc.Writer(w), r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
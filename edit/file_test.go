@@ -0,0 +1,403 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "edit_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(…)=_,%v, want _,nil", err)
+	}
+	return dir
+}
+
+func writeTestFile(t *testing.T, path, str string) {
+	if err := ioutil.WriteFile(path, []byte(str), 0666); err != nil {
+		t.Fatalf("ioutil.WriteFile(%q, …)=%v, want nil", path, err)
+	}
+}
+
+func TestReadFileEdit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	hello := filepath.Join(dir, "hello")
+	writeTestFile(t, hello, "Hello, 世界!")
+	empty := filepath.Join(dir, "empty")
+	writeTestFile(t, empty, "")
+
+	tests := []eTest{
+		{init: "", e: ReadFile(All, hello), want: "Hello, 世界!", dot: addr{0, 10}},
+		{init: "abc", e: ReadFile(All, hello), want: "Hello, 世界!", dot: addr{0, 10}},
+		{init: "abc", e: ReadFile(Rune(1), hello), want: "aHello, 世界!bc", dot: addr{1, 11}},
+		{init: "abc", e: ReadFile(Regexp("/b/"), empty), want: "ac", dot: addr{1, 1}},
+		{init: "abc", e: ReadFile(All, filepath.Join(dir, "none")), err: "no such file"},
+		{init: "abc", e: ReadFile(All, ""), err: "no file name"},
+		{init: "abc", e: LoadFile(hello), want: "Hello, 世界!", dot: addr{0, 10}},
+		{init: "abc", e: LoadFile(""), err: "no file name"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestWriteFileEdit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	tests := []struct {
+		eTest
+		file string
+	}{
+		{eTest: eTest{init: "", e: WriteFile(All, path), dot: addr{0, 0}}, file: ""},
+		{
+			eTest: eTest{init: "Hello, 世界!", want: "Hello, 世界!", e: WriteFile(All, path), dot: addr{0, 10}},
+			file:  "Hello, 世界!",
+		},
+		{
+			eTest: eTest{init: "Hello, 世界!", want: "Hello, 世界!", e: WriteFile(Regexp("/世界/"), path), dot: addr{7, 9}},
+			file:  "世界",
+		},
+		{eTest: eTest{init: "abc", e: WriteFile(All, ""), err: "no file name"}},
+		{eTest: eTest{init: "abc", e: WriteFile(All, filepath.Join(dir, "none", "x")), err: "no such file"}},
+	}
+	for _, test := range tests {
+		test.run(t)
+		if test.err != "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || string(data) != test.file {
+			t.Errorf("ed.Do(%q, pr); ioutil.ReadFile(%q)=%q,%v, want %q,nil",
+				test.e, path, data, err, test.file)
+		}
+	}
+}
+
+func TestFileNameAndDirty(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	writeTestFile(t, path, "Hello, World!")
+	other := filepath.Join(dir, "other")
+
+	buf := NewBuffer()
+	defer buf.Close()
+	ed := NewEditor(buf)
+	check := func(name string, dirty bool) {
+		if n, d := buf.FileName(), buf.Dirty(); n != name || d != dirty {
+			t.Errorf("buf.FileName(), buf.Dirty()=%q,%v, want %q,%v", n, d, name, dirty)
+		}
+	}
+	do := func(e Edit) {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+
+	check("", false)
+	do(LoadFile(path))
+	check(path, false)
+	do(Append(End, "\n"))
+	check(path, true)
+	do(WriteFile(Rune(0).To(Rune(5)), ""))
+	check(path, true)
+	do(WriteFile(All, other))
+	check(path, true)
+	do(WriteFile(All, ""))
+	check(path, false)
+	if err := ed.Undo(1); err != nil {
+		t.Fatalf("ed.Undo(1)=%v, want nil", err)
+	}
+	check(path, true)
+	do(LoadFile(""))
+	check(path, false)
+	if s := ed.String(); s != "Hello, World!\n" {
+		t.Errorf("ed.String()=%q, want %q", s, "Hello, World!\n")
+	}
+
+	// A change in the same Edit as a write is not in the file.
+	do(Block(All, WriteFile(All, ""), Append(End, "x")))
+	check(path, true)
+	do(Block(All, Append(End, "y"), WriteFile(All, "")))
+	check(path, true)
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "Hello, World!\nx" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "Hello, World!\nx")
+	}
+	do(Block(All, LoadFile(""), Append(End, "z")))
+	check(path, true)
+	do(LoadFile(""))
+	check(path, false)
+
+	buf.SetFileName("")
+	do(WriteFile(All, other))
+	check(other, false)
+}
+
+func TestUndoRedoDirty(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	writeTestFile(t, path, "Hello, World!")
+
+	buf := NewBuffer()
+	defer buf.Close()
+	ed := NewEditor(buf)
+	check := func(dirty bool) {
+		if d := buf.Dirty(); d != dirty {
+			t.Errorf("buf.Dirty()=%v, want %v", d, dirty)
+		}
+	}
+	do := func(e Edit) {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+	undo := func(n int) {
+		if err := ed.Undo(n); err != nil {
+			t.Fatalf("ed.Undo(%d)=%v, want nil", n, err)
+		}
+	}
+	redo := func(n int) {
+		if err := ed.Redo(n); err != nil {
+			t.Fatalf("ed.Redo(%d)=%v, want nil", n, err)
+		}
+	}
+
+	do(LoadFile(path))
+	do(Append(End, "x"))
+	do(Append(End, "y"))
+	check(true)
+	undo(2)
+	check(false)
+	redo(1)
+	check(true)
+	undo(1)
+	check(false)
+	undo(1)
+	check(true)
+	redo(1)
+	check(false)
+
+	// Writing makes the current state clean.
+	do(Append(End, "x"))
+	do(WriteFile(All, ""))
+	check(false)
+	undo(1)
+	check(true)
+	redo(1)
+	check(false)
+
+	// The clean state is discarded with the redo history.
+	undo(1)
+	do(Append(End, "z"))
+	check(true)
+	undo(1)
+	check(true)
+}
+
+func TestEdFile(t *testing.T) {
+	tests := []struct {
+		e, left string
+		want    Edit
+		err     string
+	}{
+		{e: "e", want: LoadFile("")},
+		{e: "e file", want: LoadFile("file")},
+		{e: "e  a file  \nxyz", left: "xyz", want: LoadFile("a file")},
+		{e: "#1e file", err: "address"},
+		{e: ".e", err: "address"},
+		{e: "r", want: ReadFile(Dot, "")},
+		{e: "r file", want: ReadFile(Dot, "file")},
+		{e: "$r file\nxyz", left: "xyz", want: ReadFile(End, "file")},
+		{e: "w", want: WriteFile(All, "")},
+		{e: "w file", want: WriteFile(All, "file")},
+		{e: "1w file", want: WriteFile(Line(1), "file")},
+		{e: "x/a/w file", want: Loop(Dot, "/a/", WriteFile(All, "file"))},
+	}
+	for _, test := range tests {
+		e, left, err := Ed([]rune(test.e))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf(`Ed(%q)=_,_,%v, want _,_,%q`, test.e, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(e, test.want) || string(left) != test.left {
+			t.Errorf(`Ed(%q)=%q,%q,%v, want %q,%q,<nil>`, test.e, e, left, err, test.want, test.left)
+		}
+	}
+}
+
+func TestWriteFileCorrupt(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	writeTestFile(t, path, "Hello, World!")
+
+	f := &memReaderAt{}
+	buf := newBuffer(runes.NewBufferReaderWriterAt(4, f))
+	defer buf.Close()
+	ed := NewEditor(buf)
+	if err := ed.Do(Change(All, "Hello, 世界!"), bytes.NewBuffer(nil)); err != nil {
+		t.Fatalf("ed.Do(Change(…))=%v, want nil", err)
+	}
+	for i := range f.data {
+		f.data[i] = ^f.data[i]
+	}
+
+	err := ed.Do(WriteFile(All, path), bytes.NewBuffer(nil))
+	if _, ok := err.(*runes.CorruptBlockError); !ok {
+		t.Errorf("ed.Do(WriteFile(All, %q), b)=%v, want *runes.CorruptBlockError", path, err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "Hello, World!" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "Hello, World!")
+	}
+}
+
+func TestWriteFileFailedEdit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	writeTestFile(t, path, "Hello, World!")
+
+	buf := NewBuffer()
+	defer buf.Close()
+	ed := NewEditor(buf)
+	if err := ed.Do(Change(All, "abcdef"), bytes.NewBuffer(nil)); err != nil {
+		t.Fatalf("ed.Do(Change(…))=%v, want nil", err)
+	}
+	e := Block(All, WriteFile(All, path), Delete(Rune(1).To(Rune(3))), Delete(Rune(2).To(Rune(4))))
+	if err := ed.Do(e, bytes.NewBuffer(nil)); err == nil {
+		t.Errorf("ed.Do(%q, b)=nil, want error", e)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "Hello, World!" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "Hello, World!")
+	}
+}
+
+func TestFileEncodingRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+
+	tests := []struct {
+		file, text string
+		enc        runes.Encoding
+		// Appended is appended to the text,
+		// and it is appended as appendedFile to the file.
+		appended, appendedFile string
+	}{
+		{
+			file:     "Hello, 世界!",
+			text:     "Hello, 世界!",
+			enc:      runes.UTF8,
+			appended: "…", appendedFile: "…",
+		},
+		{
+			file:     "\xFF\xFEH\x00i\x00\r\x00\n\x00",
+			text:     "\uFEFFHi\r\n",
+			enc:      runes.UTF16LE,
+			appended: "世", appendedFile: "\x16\x4E",
+		},
+		{
+			file:     "\xFE\xFF\x00H\x00i",
+			text:     "\uFEFFHi",
+			enc:      runes.UTF16BE,
+			appended: "世", appendedFile: "\x4E\x16",
+		},
+		{
+			file:     "caf\xE9",
+			text:     "café",
+			enc:      runes.Latin1,
+			appended: "ÿ", appendedFile: "\xFF",
+		},
+		{
+			file:     "\x93caf\xE9\x94",
+			text:     "“café”",
+			enc:      runes.Windows1252,
+			appended: "€", appendedFile: "\x80",
+		},
+		// Invalid UTF-8 after the bytes used for detection.
+		{
+			file:     strings.Repeat("a", 2*detectBytes) + "caf\xE9",
+			text:     strings.Repeat("a", 2*detectBytes) + "café",
+			enc:      runes.Latin1,
+			appended: "ÿ", appendedFile: "\xFF",
+		},
+		{
+			file:     strings.Repeat("a", 2*detectBytes) + "caf\xE9 \x93caf\xE9\x94",
+			text:     strings.Repeat("a", 2*detectBytes) + "café “café”",
+			enc:      runes.Windows1252,
+			appended: "€", appendedFile: "\x80",
+		},
+	}
+	for _, test := range tests {
+		writeTestFile(t, path, test.file)
+		buf := NewBuffer()
+		ed := NewEditor(buf)
+		if err := ed.Do(LoadFile(path), bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(LoadFile(%q), b)=%v, want nil", path, err)
+		}
+		if s, enc := ed.String(), buf.Encoding(); s != test.text || enc != test.enc {
+			t.Errorf("load %q: ed.String(), buf.Encoding()=%q,%s, want %q,%s", test.file, s, enc, test.text, test.enc)
+		}
+		if err := ed.Do(Append(End, test.appended), bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(Append(End, %q), b)=%v, want nil", test.appended, err)
+		}
+		if err := ed.Do(WriteFile(All, ""), bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(WriteFile(All, \"\"), b)=%v, want nil", err)
+		}
+		want := test.file + test.appendedFile
+		if data, err := ioutil.ReadFile(path); string(data) != want || err != nil {
+			t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, want)
+		}
+		buf.Close()
+	}
+}
+
+func TestWriteFileUnencodable(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	writeTestFile(t, path, "caf\xE9")
+
+	buf := NewBuffer()
+	defer buf.Close()
+	ed := NewEditor(buf)
+	if err := ed.Do(LoadFile(path), bytes.NewBuffer(nil)); err != nil {
+		t.Fatalf("ed.Do(LoadFile(%q), b)=%v, want nil", path, err)
+	}
+	if err := ed.Do(Append(End, " 世界"), bytes.NewBuffer(nil)); err != nil {
+		t.Fatalf("ed.Do(Append(End, \" 世界\"), b)=%v, want nil", err)
+	}
+	err := ed.Do(WriteFile(All, ""), bytes.NewBuffer(nil))
+	if _, ok := err.(*runes.EncodeError); !ok {
+		t.Errorf("ed.Do(WriteFile(All, \"\"), b)=%v, want *runes.EncodeError", err)
+	}
+	// The file is not changed.
+	if data, err := ioutil.ReadFile(path); string(data) != "caf\xE9" || err != nil {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "caf\xE9")
+	}
+
+	buf.SetEncoding(runes.UTF8)
+	if err := ed.Do(WriteFile(All, ""), bytes.NewBuffer(nil)); err != nil {
+		t.Fatalf("ed.Do(WriteFile(All, \"\"), b)=%v, want nil", err)
+	}
+	if data, err := ioutil.ReadFile(path); string(data) != "café 世界" || err != nil {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", path, data, err, "café 世界")
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+// A journal is a file recording every change applied to a Buffer,
+// from which the Buffer's contents can be recovered
+// after the process exits uncleanly.
+//
+// The journal is a sequence of entries.
+// Each entry is a header, with the same fields as a log header,
+// followed by size runes of data:
+// the new contents of the changed address.
+// Each rune of the header and data is stored
+// as a 4-byte, little-endian integer.
+// The prev field of the header is unused.
+type journal struct{ f *os.File }
+
+const journalRuneBytes = 4
+
+// NewJournaledBuffer returns a new, empty Buffer
+// that records all changes in a journal file at path.
+// If the file exists, it is truncated.
+// The journal file is synced after the changes of each Edit,
+// Undo, or Redo are recorded.
+// When the Buffer is read from or written to its file and becomes clean,
+// the journal is truncated to a single entry with the Buffer's contents,
+// so it does not grow without bound.
+// When the Buffer is closed, the journal file is removed.
+// If the process exits without closing the Buffer,
+// the Buffer can be recovered using RecoverBuffer.
+func NewJournaledBuffer(path string) (*Buffer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := NewBuffer()
+	buf.journal = &journal{f: f}
+	return buf, nil
+}
+
+// RecoverBuffer returns a new Buffer with the contents
+// and sequence number recorded in the journal file at path.
+// An incomplete entry at the end of the journal,
+// from a change that was not completely recorded, is ignored.
+// The returned Buffer continues to record changes in the journal,
+// just as if it were returned by NewJournaledBuffer.
+func RecoverBuffer(path string) (*Buffer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := NewBuffer()
+	fi, err := f.Stat()
+	var end int64
+	if err == nil {
+		end, err = replay(buf, bufio.NewReader(f), fi.Size())
+	}
+	if err == nil {
+		// Drop any incomplete entry.
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		buf.Close()
+		return nil, err
+	}
+	buf.journal = &journal{f: f}
+	return buf, nil
+}
+
+// Replay applies the changes from a journal of the given size in bytes
+// to the Buffer,
+// and returns the byte offset of the end of the last complete entry.
+// Each group of replayed changes with the same sequence number
+// can be undone.
+func replay(buf *Buffer, r io.Reader, size int64) (int64, error) {
+	var end int64
+	hdr := make([]rune, headerRunes)
+	for {
+		switch err := readJournalRunes(r, hdr); {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return end, nil
+		case err != nil:
+			return end, err
+		}
+		var h header
+		h.unmarshal(hdr)
+		left := size - end - headerRunes*journalRuneBytes
+		if h.size < 0 || h.size > left/journalRuneBytes ||
+			h.at.from < 0 || h.at.from > h.at.to || h.at.to > buf.size() {
+			// The header is corrupt or its data is incomplete.
+			return end, nil
+		}
+		if end == 0 || h.seq != buf.seq-1 {
+			// There are no marks from before a recovered change,
+			// so undoing it sets dot to the reverted runes.
+			buf.undo.marks = append(buf.undo.marks, nil)
+		}
+		data := &journalReader{r: r, n: h.size}
+		if _, err := buf.change(h.at, data, buf.undo.log, h.seq, h.who); err != nil {
+			return end, err
+		}
+		buf.seq = h.seq + 1
+		// The recovered changes were never written to the file.
+		buf.clean = -1
+		buf.dirty = true
+		end += (headerRunes + h.size) * journalRuneBytes
+	}
+}
+
+// A journalReader reads the n runes of data of a journal entry.
+type journalReader struct {
+	r io.Reader
+	n int64
+}
+
+func (jr *journalReader) Read(p []rune) (int, error) {
+	if jr.n == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > runes.MinRead {
+		p = p[:runes.MinRead]
+	}
+	if int64(len(p)) > jr.n {
+		p = p[:jr.n]
+	}
+	if err := readJournalRunes(jr.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	jr.n -= int64(len(p))
+	return len(p), nil
+}
+
+func readJournalRunes(r io.Reader, rs []rune) error {
+	bs := make([]byte, len(rs)*journalRuneBytes)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return err
+	}
+	for i := range rs {
+		rs[i] = rune(binary.LittleEndian.Uint32(bs[i*journalRuneBytes:]))
+	}
+	return nil
+}
+
+// Close closes and removes the journal file.
+func (j *journal) close() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.f.Name())
+}
+
+// Reset replaces the entries of the journal
+// with a single entry changing the empty string
+// to the entire contents of the Buffer,
+// and syncs the journal file.
+//
+// This method must be called with the Buffer's Lock held.
+func (j *journal) reset(buf *Buffer, seq, who int32) error {
+	if err := j.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data := runes.LimitReader(buf.runes.Reader(0), buf.size())
+	if err := j.append(addr{}, buf.size(), seq, who, data); err != nil {
+		return err
+	}
+	return j.sync()
+}
+
+// Sync commits the journal file to stable storage.
+func (j *journal) sync() error { return j.f.Sync() }
+
+// Append appends an entry for a change to the journal.
+// The Reader must contain exactly size runes.
+func (j *journal) append(at addr, size int64, seq, who int32, src runes.Reader) error {
+	h := header{at: at, size: size, seq: seq, who: who}
+	w := bufio.NewWriter(j.f)
+	if err := writeJournalRunes(w, h.marshal()); err != nil {
+		return err
+	}
+	var p [runes.MinRead]rune
+	for {
+		n, err := src.Read(p[:])
+		if werr := writeJournalRunes(w, p[:n]); werr != nil {
+			return werr
+		}
+		switch {
+		case err == io.EOF:
+			return w.Flush()
+		case err != nil:
+			return err
+		}
+	}
+}
+
+func writeJournalRunes(w io.Writer, rs []rune) error {
+	var bs [journalRuneBytes]byte
+	for _, r := range rs {
+		binary.LittleEndian.PutUint32(bs[:], uint32(r))
+		if _, err := w.Write(bs[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
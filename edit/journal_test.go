@@ -0,0 +1,233 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Crash closes the Buffer without removing its journal.
+func crash(t *testing.T, buf *Buffer) {
+	if err := buf.journal.f.Close(); err != nil {
+		t.Fatalf("buf.journal.f.Close()=%v, want nil", err)
+	}
+	buf.journal = nil
+	if err := buf.Close(); err != nil {
+		t.Fatalf("buf.Close()=%v, want nil", err)
+	}
+}
+
+func TestRecoverBuffer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	buf, err := NewJournaledBuffer(path)
+	if err != nil {
+		t.Fatalf("NewJournaledBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	ed := NewEditor(buf)
+	for _, e := range []Edit{
+		Change(All, "Hello, World!\n"),
+		SubGlobal(All, "/o/", "0"),
+		Append(End, "Hello, 世界!\n"),
+		Delete(Line(1)),
+		Undo(1),
+	} {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+	want, seq := ed.String(), buf.seq
+	crash(t, buf)
+
+	buf, err = RecoverBuffer(path)
+	if err != nil {
+		t.Fatalf("RecoverBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	ed = NewEditor(buf)
+	if s := ed.String(); s != want {
+		t.Errorf("recovered %q, want %q", s, want)
+	}
+	if buf.seq != seq {
+		t.Errorf("recovered seq=%d, want %d", buf.seq, seq)
+	}
+	if !buf.Dirty() {
+		t.Errorf("recovered buf.Dirty()=false, want true")
+	}
+	if l := buf.lines.len(); l != 2 {
+		t.Errorf("recovered buf.lines.len()=%d, want 2", l)
+	}
+
+	// The recovered Buffer continues to journal its changes.
+	if err := ed.change(Line(1), "Hi\n"); err != nil {
+		t.Fatalf("ed.change(Line(1), \"Hi\\n\")=%v, want nil", err)
+	}
+	want = ed.String()
+	crash(t, buf)
+
+	// An incomplete entry at the end is ignored.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("os.OpenFile(%q, …)=_,%v, want _,nil", path, err)
+	}
+	if _, err := f.Write([]byte{1, 2, 3, 4, 5, 6, 7}); err != nil {
+		t.Fatalf("f.Write(…)=_,%v, want _,nil", err)
+	}
+	f.Close()
+
+	buf, err = RecoverBuffer(path)
+	if err != nil {
+		t.Fatalf("RecoverBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	ed = NewEditor(buf)
+	if s := ed.String(); s != want {
+		t.Errorf("recovered %q, want %q", s, want)
+	}
+
+	// Closing the Buffer removes the journal.
+	if err := buf.Close(); err != nil {
+		t.Fatalf("buf.Close()=%v, want nil", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q)=_,%v, want not exist", path, err)
+	}
+}
+
+func TestRecoverBufferBadSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	for _, size := range []int64{-5, 1 << 40, 2} {
+		buf, err := NewJournaledBuffer(path)
+		if err != nil {
+			t.Fatalf("NewJournaledBuffer(%q)=_,%v, want _,nil", path, err)
+		}
+		ed := NewEditor(buf)
+		if err := ed.change(All, "Hello"); err != nil {
+			t.Fatalf("ed.change(All, \"Hello\")=%v, want nil", err)
+		}
+		// An entry with a bad size, but only one rune of data.
+		h := header{at: addr{0, 5}, size: size, seq: 1}
+		if err := writeJournalRunes(buf.journal.f, append(h.marshal(), 'x')); err != nil {
+			t.Fatalf("writeJournalRunes(…)=%v, want nil", err)
+		}
+		crash(t, buf)
+
+		buf, err = RecoverBuffer(path)
+		if err != nil {
+			t.Fatalf("size %d: RecoverBuffer(%q)=_,%v, want _,nil", size, path, err)
+		}
+		ed = NewEditor(buf)
+		if s := ed.String(); s != "Hello" {
+			t.Errorf("size %d: recovered %q, want %q", size, s, "Hello")
+		}
+		if err := buf.Close(); err != nil {
+			t.Fatalf("buf.Close()=%v, want nil", err)
+		}
+	}
+}
+
+func TestRecoverBufferUndo(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	buf, err := NewJournaledBuffer(path)
+	if err != nil {
+		t.Fatalf("NewJournaledBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	ed := NewEditor(buf)
+	for _, e := range []Edit{
+		Change(All, "abc abc"),
+		SubGlobal(All, "/b/", "B"),
+		Append(End, "!"),
+	} {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+	crash(t, buf)
+
+	buf, err = RecoverBuffer(path)
+	if err != nil {
+		t.Fatalf("RecoverBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	defer buf.Close()
+	if n := len(buf.undo.marks); n != 3 {
+		t.Errorf("len(buf.undo.marks)=%d, want 3", n)
+	}
+	ed = NewEditor(buf)
+	for _, want := range []string{"aBc aBc", "abc abc", ""} {
+		if err := ed.Undo(1); err != nil {
+			t.Fatalf("ed.Undo(1)=%v, want nil", err)
+		}
+		if s := ed.String(); s != want {
+			t.Errorf("after undo %q, want %q", s, want)
+		}
+	}
+	if err := ed.Redo(1); err != nil {
+		t.Fatalf("ed.Redo(1)=%v, want nil", err)
+	}
+	if s := ed.String(); s != "abc abc" {
+		t.Errorf("after redo %q, want %q", s, "abc abc")
+	}
+}
+
+func TestRecoverBufferWritten(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+	file := filepath.Join(dir, "file")
+
+	buf, err := NewJournaledBuffer(path)
+	if err != nil {
+		t.Fatalf("NewJournaledBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	ed := NewEditor(buf)
+	for _, e := range []Edit{
+		Change(All, "Hello"),
+		Append(End, ", World"),
+		WriteFile(All, file),
+	} {
+		if err := ed.Do(e, bytes.NewBuffer(nil)); err != nil {
+			t.Fatalf("ed.Do(%q, b)=%v, want nil", e, err)
+		}
+	}
+	// Writing the Buffer clean truncates the journal to one entry.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat(%q)=_,%v, want _,nil", path, err)
+	}
+	if sz := int64(headerRunes+len("Hello, World")) * journalRuneBytes; fi.Size() != sz {
+		t.Errorf("journal size=%d, want %d", fi.Size(), sz)
+	}
+	if err := ed.Do(Append(End, "!"), bytes.NewBuffer(nil)); err != nil {
+		t.Fatalf("ed.Do(Append(End, \"!\"), b)=%v, want nil", err)
+	}
+	seq := buf.seq
+	crash(t, buf)
+
+	buf, err = RecoverBuffer(path)
+	if err != nil {
+		t.Fatalf("RecoverBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	defer buf.Close()
+	ed = NewEditor(buf)
+	if s := ed.String(); s != "Hello, World!" {
+		t.Errorf("recovered %q, want %q", s, "Hello, World!")
+	}
+	if buf.seq != seq {
+		t.Errorf("recovered seq=%d, want %d", buf.seq, seq)
+	}
+	if err := ed.Undo(1); err != nil {
+		t.Fatalf("ed.Undo(1)=%v, want nil", err)
+	}
+	if s := ed.String(); s != "Hello, World" {
+		t.Errorf("after undo %q, want %q", s, "Hello, World")
+	}
+}
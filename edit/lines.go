@@ -0,0 +1,184 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"io"
+	"math/rand"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+// A lineIndex indexes the offsets of the newlines in a Buffer.
+// It maps between line numbers and rune offsets in logarithmic time.
+//
+// The index is a treap with a node for each newline, in order.
+// Each node holds the number of runes since the previous newline,
+// so a change only needs to update the nodes near the change.
+type lineIndex struct{ root *lineNode }
+
+type lineNode struct {
+	left, right *lineNode
+	pri         int32
+	// Gap is the number of runes after the previous newline,
+	// up to and including this newline.
+	gap int64
+	// N is the number of nodes in the subtree.
+	n int
+	// Sum is the sum of the gaps of the subtree.
+	sum int64
+}
+
+func (t *lineNode) size() int {
+	if t == nil {
+		return 0
+	}
+	return t.n
+}
+
+func (t *lineNode) total() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.sum
+}
+
+func (t *lineNode) fix() *lineNode {
+	t.n = t.left.size() + 1 + t.right.size()
+	t.sum = t.left.total() + t.gap + t.right.total()
+	return t
+}
+
+// Len returns the number of newlines in the index.
+func (x *lineIndex) len() int { return x.root.size() }
+
+// Count returns the number of newlines at offsets less than p.
+func (x *lineIndex) count(p int64) int {
+	var k int
+	var q0 int64
+	for t := x.root; t != nil; {
+		q := q0 + t.left.total() + t.gap
+		if q-1 < p {
+			k += t.left.size() + 1
+			q0 = q
+			t = t.right
+		} else {
+			t = t.left
+		}
+	}
+	return k
+}
+
+// Pos returns the offset of the kth newline.
+// The first newline is k=1.
+// Pos panics if k is out of range.
+func (x *lineIndex) pos(k int) int64 {
+	if k < 1 || k > x.len() {
+		panic("newline index out of range")
+	}
+	var q0 int64
+	t := x.root
+	for {
+		switch l := t.left.size(); {
+		case k <= l:
+			t = t.left
+		case k == l+1:
+			return q0 + t.left.total() + t.gap - 1
+		default:
+			k -= l + 1
+			q0 += t.left.total() + t.gap
+			t = t.right
+		}
+	}
+}
+
+// LineStart returns the offset of the start of the line containing offset p.
+func (x *lineIndex) lineStart(p int64) int64 {
+	if k := x.count(p); k > 0 {
+		return x.pos(k) + 1
+	}
+	return 0
+}
+
+// Change updates the index for the string at changing to n runes.
+// Nls are the offsets of the newlines in the new runes,
+// relative to the start of at, in increasing order.
+func (x *lineIndex) change(at addr, n int64, nls []int64) {
+	kFrom, kTo := x.count(at.from), x.count(at.to)
+	l, r := splitLines(x.root, kFrom)
+	m, r := splitLines(r, kTo-kFrom)
+
+	var ins *lineNode
+	prev := l.total() - 1 // offset of the last newline in l, or -1
+	for _, o := range nls {
+		q := at.from + o
+		ins = mergeLines(ins, (&lineNode{pri: rand.Int31(), gap: q - prev}).fix())
+		prev = q
+	}
+
+	if r != nil {
+		var r1 *lineNode
+		r1, r = splitLines(r, 1)
+		q := l.total() + m.total() + r1.gap - 1
+		q += n - at.size()
+		r1.gap = q - prev
+		r = mergeLines(r1.fix(), r)
+	}
+	x.root = mergeLines(l, mergeLines(ins, r))
+}
+
+// SplitLines returns the first k nodes of t and the remaining nodes.
+func splitLines(t *lineNode, k int) (*lineNode, *lineNode) {
+	if t == nil {
+		return nil, nil
+	}
+	if k <= t.left.size() {
+		a, b := splitLines(t.left, k)
+		t.left = b
+		return a, t.fix()
+	}
+	a, b := splitLines(t.right, k-t.left.size()-1)
+	t.right = a
+	return t.fix(), b
+}
+
+// MergeLines returns the nodes of a followed by the nodes of b.
+func mergeLines(a, b *lineNode) *lineNode {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.pri > b.pri:
+		a.right = mergeLines(a.right, b)
+		return a.fix()
+	default:
+		b.left = mergeLines(a, b.left)
+		return b.fix()
+	}
+}
+
+// Newlines returns the offsets of the newlines
+// in the n runes of rs beginning at from,
+// relative to from.
+func newlines(rs *runes.Buffer, from, n int64) ([]int64, error) {
+	var nls []int64
+	var o int64
+	r := runes.LimitReader(rs.Reader(from), n)
+	var p [runes.MinRead]rune
+	for {
+		m, err := r.Read(p[:])
+		for _, ru := range p[:m] {
+			if ru == '\n' {
+				nls = append(nls, o)
+			}
+			o++
+		}
+		switch {
+		case err == io.EOF:
+			return nls, nil
+		case err != nil:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLineIndex(t *testing.T) {
+	rand.Seed(0) // For reproducibility.
+	ed := NewEditor(NewBuffer())
+	defer ed.buf.Close()
+
+	const alpha = "ab\n世\n"
+	randString := func(n int) string {
+		var rs []rune
+		for i := 0; i < n; i++ {
+			rs = append(rs, []rune(alpha)[rand.Intn(len([]rune(alpha)))])
+		}
+		return string(rs)
+	}
+	for i := 0; i < 500; i++ {
+		size := ed.buf.size()
+		from := rand.Int63n(size + 1)
+		to := from + rand.Int63n(size-from+1)
+		str := randString(rand.Intn(20))
+		if err := ed.change(Rune(from).To(Rune(to)), str); err != nil {
+			t.Fatalf("ed.change(#%d,#%d, %q)=%v, want nil", from, to, str, err)
+		}
+		checkLineIndex(t, ed)
+	}
+}
+
+func checkLineIndex(t *testing.T, ed *Editor) {
+	rs := []rune(ed.String())
+	lines := &ed.buf.lines
+	if n, want := lines.len(), strings.Count(string(rs), "\n"); n != want {
+		t.Fatalf("lines.len()=%d, want %d", n, want)
+	}
+	var k int
+	for i, r := range rs {
+		if c := lines.count(int64(i)); c != k {
+			t.Fatalf("lines.count(%d)=%d, want %d", i, c, k)
+		}
+		if r == '\n' {
+			k++
+			if p := lines.pos(k); p != int64(i) {
+				t.Fatalf("lines.pos(%d)=%d, want %d", k, p, i)
+			}
+		}
+	}
+	if c := lines.count(int64(len(rs))); c != k {
+		t.Fatalf("lines.count(%d)=%d, want %d", len(rs), c, k)
+	}
+}
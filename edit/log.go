@@ -2,7 +2,11 @@
 
 package edit
 
-import "github.com/eaburns/T/edit/runes"
+import (
+	"sort"
+
+	"github.com/eaburns/T/edit/runes"
+)
 
 // A log holds a record of changes made to a buffer.
 // It consists of an unbounded number of entries.
@@ -29,6 +33,19 @@ func (l *log) clear() error {
 	return l.buf.Delete(l.buf.Size(), 0)
 }
 
+// Truncate removes the entry and all entries following it from the log.
+// Truncating the dummy end entry does nothing.
+func (l *log) truncate(e entry) error {
+	switch {
+	case e.end():
+		return nil
+	case e.offs == 0:
+		return l.clear()
+	}
+	l.last = e.header.prev
+	return l.buf.Delete(l.buf.Size()-e.offs, e.offs)
+}
+
 type header struct {
 	// Prev is the offset into the log
 	// of the beginning of the previous entry's header.
@@ -78,12 +95,17 @@ func (h *header) unmarshal(data []rune) {
 }
 
 func (l *log) append(seq, who int32, at addr, src runes.Reader) error {
-	prev := l.last
-	l.last = l.buf.Size()
-	n, err := runes.Copy(l.buf.Writer(l.last), src)
+	start := l.buf.Size()
+	n, err := runes.Copy(l.buf.Writer(start), src)
 	if err != nil {
+		// Remove the partial data, leaving the log as it was.
+		if err := l.buf.Delete(n, start); err != nil {
+			return err
+		}
 		return err
 	}
+	prev := l.last
+	l.last = start
 	// Insert the header before the data.
 	h := header{
 		prev: prev,
@@ -203,3 +225,44 @@ func (e entry) data() runes.Reader {
 	from := e.offs + headerRunes
 	return runes.LimitReader(e.l.buf.Reader(from), e.size)
 }
+
+// Sort stably sorts the entries of the log,
+// from the entry e to the end of the log,
+// by their addresses.
+func (l *log) sort(e entry) error {
+	var es []entry
+	for ; !e.end(); e = e.next() {
+		es = append(es, e)
+	}
+	if e.err != nil {
+		return e.err
+	}
+	less := func(i, j int) bool {
+		a, b := es[i].at, es[j].at
+		return a.from < b.from || a.from == b.from && a.to < b.to
+	}
+	if len(es) == 0 || sort.SliceIsSorted(es, less) {
+		return nil
+	}
+	first := es[0]
+	sort.SliceStable(es, less)
+
+	// Copy the entries in sorted order to a temporary log,
+	// and then back in place of the originals.
+	tmp := newLog()
+	defer tmp.close()
+	for _, e := range es {
+		if err := tmp.append(e.seq, e.who, e.at, e.data()); err != nil {
+			return err
+		}
+	}
+	if err := l.truncate(first); err != nil {
+		return err
+	}
+	for e = logFirst(tmp); !e.end(); e = e.next() {
+		if err := l.append(e.seq, e.who, e.at, e.data()); err != nil {
+			return err
+		}
+	}
+	return e.err
+}
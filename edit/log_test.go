@@ -4,6 +4,7 @@ package edit
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/eaburns/T/edit/runes"
@@ -151,6 +152,63 @@ func TestEntryStore(t *testing.T) {
 	}
 }
 
+func TestLogSort(t *testing.T) {
+	entries := []testEntry{
+		{seq: 0, who: 0, at: addr{5, 6}, str: "Hello, World!"},
+		{seq: 1, who: 0, at: addr{8, 10}, str: "Foo, Bar, Baz"},
+		{seq: 1, who: 1, at: addr{0, 5}, str: "Ms. Pepper"},
+		{seq: 1, who: 2, at: addr{0, 0}, str: ""},
+		{seq: 1, who: 3, at: addr{0, 5}, str: "Hello, 世界"},
+	}
+	l := initTestLog(t, entries)
+	defer l.close()
+
+	// Sort all but the first entry.
+	if err := l.sort(logFirst(l).next()); err != nil {
+		t.Fatalf("l.sort(…)=%v, want nil", err)
+	}
+	want := []testEntry{entries[0], entries[3], entries[2], entries[4], entries[1]}
+	e := logFirst(l)
+	for i := range want {
+		checkEntry(t, i, want, e)
+		e = e.next()
+	}
+	if !e.end() {
+		t.Fatalf("end: e.end()=false, want true")
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		e = e.prev()
+		checkEntry(t, i, want, e)
+	}
+}
+
+func TestLogAppendError(t *testing.T) {
+	entries := []testEntry{
+		{seq: 0, who: 0, at: addr{0, 0}, str: "Hello, World!"},
+		{seq: 1, who: 2, at: addr{0, 5}, str: "Foo, Bar, Baz"},
+	}
+	l := initTestLog(t, entries)
+	defer l.close()
+
+	// The invalid UTF-8 is an error after some runes are read.
+	src := runes.StrictUTF8Reader(strings.NewReader("Hello, \xFF"))
+	if err := l.append(2, 1, addr{20, 50}, src); err == nil {
+		t.Fatalf("l.append(…)=nil, want error")
+	}
+	// The log is unchanged.
+	e := logFirst(l)
+	for i := range entries {
+		checkEntry(t, i, entries, e)
+		e = e.next()
+	}
+	if !e.end() {
+		t.Fatalf("end: e.end()=false, want true")
+	}
+	if e = logLast(l); e.seq != 1 {
+		t.Errorf("logLast(l).seq=%d, want 1", e.seq)
+	}
+}
+
 type testEntry struct {
 	seq, who int32
 	at       addr
@@ -0,0 +1,153 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"bufio"
+	"io"
+	"os/exec"
+
+	"github.com/eaburns/T/edit/runes"
+)
+
+// Shell is the shell used to run commands.
+// The command is passed to the shell with the -c flag.
+const shell = "/bin/sh"
+
+type pipe struct {
+	a   Address
+	op  rune
+	cmd string
+}
+
+// Pipe returns an Edit
+// that changes the string at a
+// to the output of a shell command
+// run with the string at a as its standard input,
+// and sets dot to the changed runes.
+// The command's standard error is written to the io.Writer.
+//
+// The command may be run more than once
+// if the Buffer is changed concurrently.
+func Pipe(a Address, cmd string) Edit { return pipe{a: a, op: '|', cmd: cmd} }
+
+// PipeIn returns an Edit
+// that changes the string at a
+// to the output of a shell command,
+// and sets dot to the changed runes.
+// The command's standard error is written to the io.Writer.
+//
+// The command may be run more than once
+// if the Buffer is changed concurrently.
+func PipeIn(a Address, cmd string) Edit { return pipe{a: a, op: '<', cmd: cmd} }
+
+// PipeOut returns an Edit
+// that runs a shell command with the string at a as its standard input,
+// and sets dot to a.
+// The command's standard output and standard error
+// are written to the io.Writer.
+//
+// The command may be run more than once
+// if the Buffer is changed concurrently.
+func PipeOut(a Address, cmd string) Edit { return pipe{a: a, op: '>', cmd: cmd} }
+
+// Run returns an Edit
+// that runs a shell command.
+// The command's standard output and standard error
+// are written to the io.Writer.
+// Dot is unchanged.
+//
+// The command may be run more than once
+// if the Buffer is changed concurrently.
+func Run(cmd string) Edit { return pipe{a: Dot, op: '!', cmd: cmd} }
+
+func (e pipe) String() string {
+	s := string(e.op) + e.cmd
+	if e.op != '!' {
+		s = e.a.String() + s
+	}
+	return s
+}
+
+func (e pipe) do(ed *Editor, w io.Writer) (addr, error) {
+	at, err := e.a.where(ed)
+	if err != nil {
+		return addr{}, err
+	}
+	cmd := exec.Command(shell, "-c", e.cmd)
+	cmd.Stderr = w
+
+	var stdin io.WriteCloser
+	if e.op == '|' || e.op == '>' {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return addr{}, err
+		}
+	}
+	var stdout io.ReadCloser
+	if e.op == '|' || e.op == '<' {
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return addr{}, err
+		}
+	} else {
+		cmd.Stdout = w
+	}
+	if err := cmd.Start(); err != nil {
+		return addr{}, err
+	}
+
+	var inErr chan error
+	if stdin != nil {
+		inErr = make(chan error, 1)
+		go func() {
+			r := runes.LimitReader(ed.buf.runes.Reader(at.from), at.size())
+			inErr <- writeInput(stdin, r)
+		}()
+	}
+	var outErr error
+	if stdout != nil {
+		outErr = pend(ed, at, runes.UTF8Reader(stdout))
+		if outErr != nil {
+			cmd.Process.Kill()
+		}
+	}
+	err = cmd.Wait()
+	if inErr != nil {
+		if ierr := <-inErr; ierr != nil {
+			return addr{}, ierr
+		}
+	}
+	switch {
+	case outErr != nil:
+		return addr{}, outErr
+	case err != nil:
+		return addr{}, err
+	case e.op == '!':
+		return ed.marks['.'], nil
+	default:
+		return at, nil
+	}
+}
+
+// WriteInput writes the runes from r to a command's standard input as UTF-8,
+// closing the input when done.
+// Errors writing to the command are not reported,
+// as the command may exit without reading all of its input.
+func writeInput(stdin io.WriteCloser, r runes.Reader) error {
+	defer stdin.Close()
+	b := bufio.NewWriter(stdin)
+	w := runes.UTF8Writer(b)
+	var p [runes.MinRead]rune
+	for {
+		n, err := r.Read(p[:])
+		if _, werr := w.Write(p[:n]); werr != nil {
+			return nil
+		}
+		switch {
+		case err == io.EOF:
+			b.Flush()
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPipeEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: Pipe(All, "tr a-z A-Z"), want: "", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Pipe(All, "tr a-z A-Z"), want: "HELLO, 世界!", dot: addr{0, 10}},
+		{init: "Hello, 世界!", e: Pipe(Regexp("/世界/"), "cat; printf xyz"), want: "Hello, 世界xyz!", dot: addr{7, 12}},
+		{init: "Hello, 世界!", e: Pipe(All, "printf abc"), want: "abc", dot: addr{0, 3}},
+		{init: "Hello, 世界!", e: Pipe(All, "printf abc >&2"), want: "", print: "abc", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Pipe(All, "exit 1"), err: "exit status 1"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestPipeInEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: PipeIn(All, "printf 世界"), want: "世界", dot: addr{0, 2}},
+		{init: "Hello, 世界!", e: PipeIn(Regexp("/世界/"), "printf World"), want: "Hello, World!", dot: addr{7, 12}},
+		{init: "Hello, 世界!", e: PipeIn(End, "cat"), want: "Hello, 世界!", dot: addr{10, 10}},
+		{init: "Hello, 世界!", e: PipeIn(All, "exit 1"), err: "exit status 1"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestPipeOutEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: PipeOut(All, "cat"), want: "", print: "", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: PipeOut(All, "cat"), want: "Hello, 世界!", print: "Hello, 世界!", dot: addr{0, 10}},
+		{init: "Hello, 世界!", e: PipeOut(Regexp("/世界/"), "wc -c"), want: "Hello, 世界!", print: "6\n", dot: addr{7, 9}},
+		{init: "Hello, 世界!", e: PipeOut(All, "true"), want: "Hello, 世界!", dot: addr{0, 10}},
+		{init: "Hello, 世界!", e: PipeOut(All, "exit 1"), err: "exit status 1"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestRunEdit(t *testing.T) {
+	tests := []eTest{
+		{init: "", e: Run("echo 世界"), print: "世界\n", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Run("echo 世界"), want: "Hello, 世界!", print: "世界\n", dot: addr{0, 0}},
+		{init: "Hello, 世界!", e: Run("exit 1"), err: "exit status 1"},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestEdPipe(t *testing.T) {
+	tests := []struct {
+		e, left string
+		want    Edit
+	}{
+		{e: "|", want: Pipe(Dot, "")},
+		{e: "|sort", want: Pipe(Dot, "sort")},
+		{e: ",| sort -n \nxyz", left: "xyz", want: Pipe(Line(0).To(End), "sort -n")},
+		{e: "<date", want: PipeIn(Dot, "date")},
+		{e: "$< date", want: PipeIn(End, "date")},
+		{e: ">wc", want: PipeOut(Dot, "wc")},
+		{e: "1> wc -l", want: PipeOut(Line(1), "wc -l")},
+		{e: "!ls", want: Run("ls")},
+		{e: "! ls -l\nxyz", left: "xyz", want: Run("ls -l")},
+		{e: "x/a/|tr a A", want: Loop(Dot, "/a/", Pipe(Dot, "tr a A"))},
+	}
+	for _, test := range tests {
+		e, left, err := Ed([]rune(test.e))
+		if err != nil || !reflect.DeepEqual(e, test.want) || string(left) != test.left {
+			t.Errorf(`Ed(%q)=%q,%q,%v, want %q,%q,<nil>`, test.e, e, left, err, test.want, test.left)
+		}
+	}
+}
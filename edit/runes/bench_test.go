@@ -80,3 +80,39 @@ func BenchmarkRune10kRand(b *testing.B)   { benchmarkRune(b, 1048576, true) }
 func BenchmarkRune10kScan(b *testing.B)   { benchmarkRune(b, 1048576, false) }
 func BenchmarkRuneCacheRand(b *testing.B) { benchmarkRune(b, benchBlockSize, true) }
 func BenchmarkRuneCacheScan(b *testing.B) { benchmarkRune(b, benchBlockSize, false) }
+
+func benchmarkIterator(b *testing.B, n int, rev bool) {
+	r := NewBuffer(benchBlockSize)
+	defer r.Close()
+	r.Insert(randomRunes(n), 0)
+	it := r.Iterator(0)
+	b.SetBytes(runeBytes)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := int64(i % n)
+		if rev {
+			j = int64(n) - j - 1
+		}
+		it.Rune(j)
+	}
+}
+
+func BenchmarkIterator10kScan(b *testing.B)    { benchmarkIterator(b, 1048576, false) }
+func BenchmarkIterator10kReverse(b *testing.B) { benchmarkIterator(b, 1048576, true) }
+
+func BenchmarkIndex10k(b *testing.B) {
+	const n = 1048576
+	r := NewBuffer(benchBlockSize)
+	defer r.Close()
+	rs := make([]rune, n)
+	for i := range rs {
+		rs[i] = 'a' + rune(i%26)
+	}
+	r.Insert(rs, 0)
+	pat := []rune("Hello, World!")
+	b.SetBytes(n * runeBytes)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Index(pat, 0)
+	}
+}
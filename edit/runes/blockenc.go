@@ -0,0 +1,148 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+)
+
+// A BlockEncoding is a format in which
+// a Buffer stores its blocks in the backing store.
+type BlockEncoding int
+
+const (
+	// RawBlocks stores each rune in 4 little-endian bytes.
+	// It is the default.
+	RawBlocks BlockEncoding = iota
+	// VarintBlocks stores each rune as a variable-length integer
+	// of 1 to 5 bytes, so ASCII text uses 1 byte per rune.
+	VarintBlocks
+	// CompressedBlocks stores blocks in the VarintBlocks format,
+	// compressed with DEFLATE.
+	CompressedBlocks
+)
+
+// MinSpace is the minimum number of bytes allocated
+// for a block that is not stored in RawBlocks format.
+const minSpace = 16
+
+// ErrBadBlock is returned when a block read from the backing store
+// is truncated, does not match its checksum, or cannot be decoded.
+var errBadBlock = errors.New("malformed block")
+
+// SetBlockEncoding sets the format of blocks
+// that are subsequently written to the backing store.
+// Blocks that are already in the backing store keep their format
+// until they are next written.
+//
+// Blocks stored in RawBlocks format always use 4 bytes per rune
+// of the block size, but the other formats use space
+// proportional to the encoded size of each block.
+func (b *Buffer) SetBlockEncoding(enc BlockEncoding) {
+	if enc < RawBlocks || enc > CompressedBlocks {
+		panic("bad block encoding")
+	}
+	b.enc = enc
+}
+
+// SpaceFor returns the number of bytes to allocate in the backing store
+// for a block that encodes to n bytes.
+func (b *Buffer) spaceFor(n int) int {
+	if b.enc == RawBlocks {
+		return b.blockSize * runeBytes
+	}
+	s := minSpace
+	for s < n {
+		s *= 2
+	}
+	return s
+}
+
+// Encode returns the runes encoded in the Buffer's block format.
+func (b *Buffer) encode(rs []rune) ([]byte, error) {
+	switch b.enc {
+	case RawBlocks:
+		bs := make([]byte, len(rs)*runeBytes)
+		for i, r := range rs {
+			binary.LittleEndian.PutUint32(bs[i*runeBytes:], uint32(r))
+		}
+		return bs, nil
+	case VarintBlocks:
+		return appendVarints(nil, rs), nil
+	case CompressedBlocks:
+		var buf bytes.Buffer
+		if b.zw == nil {
+			zw, err := flate.NewWriter(&buf, flate.BestSpeed)
+			if err != nil {
+				return nil, err
+			}
+			b.zw = zw
+		} else {
+			b.zw.Reset(&buf)
+		}
+		if _, err := b.zw.Write(appendVarints(nil, rs)); err != nil {
+			return nil, err
+		}
+		if err := b.zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		panic("bad block encoding")
+	}
+}
+
+func appendVarints(bs []byte, rs []rune) []byte {
+	var p [binary.MaxVarintLen32]byte
+	for _, r := range rs {
+		n := binary.PutUvarint(p[:], uint64(uint32(r)))
+		bs = append(bs, p[:n]...)
+	}
+	return bs
+}
+
+// DecodeBlock decodes the runes of a block in the given format into data,
+// and returns the number of runes decoded.
+func decodeBlock(enc BlockEncoding, bs []byte, data []rune) (int, error) {
+	switch enc {
+	case RawBlocks:
+		if len(bs)%runeBytes != 0 || len(bs)/runeBytes > len(data) {
+			return 0, errBadBlock
+		}
+		j := 0
+		for len(bs) > 0 {
+			data[j] = rune(binary.LittleEndian.Uint32(bs))
+			bs = bs[runeBytes:]
+			j++
+		}
+		return j, nil
+	case VarintBlocks:
+		return decodeVarints(bs, data)
+	case CompressedBlocks:
+		vs, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(bs)))
+		if err != nil {
+			return 0, errBadBlock
+		}
+		return decodeVarints(vs, data)
+	default:
+		return 0, errBadBlock
+	}
+}
+
+func decodeVarints(bs []byte, data []rune) (int, error) {
+	j := 0
+	for len(bs) > 0 {
+		x, n := binary.Uvarint(bs)
+		if n <= 0 || x > 1<<32-1 || j == len(data) {
+			return 0, errBadBlock
+		}
+		data[j] = rune(uint32(x))
+		bs = bs[n:]
+		j++
+	}
+	return j, nil
+}
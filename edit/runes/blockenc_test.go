@@ -0,0 +1,108 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var blockEncodings = []BlockEncoding{RawBlocks, VarintBlocks, CompressedBlocks}
+
+func TestBlockEncodingRoundTrip(t *testing.T) {
+	rs := []rune{0, 'a', 0x7F, 0x80, 'α', '世', 0xFFFD, 0x10FFFF, -1, 1<<31 - 1}
+	for _, enc := range blockEncodings {
+		b := NewBuffer(len(rs))
+		b.SetBlockEncoding(enc)
+		bs, err := b.encode(rs)
+		if err != nil {
+			t.Fatalf("encoding %d: b.encode(…)=_,%v, want _,nil", enc, err)
+		}
+		data := make([]rune, len(rs))
+		n, err := decodeBlock(enc, bs, data)
+		if n != len(rs) || err != nil {
+			t.Fatalf("encoding %d: decodeBlock(…)=%d,%v, want %d,nil", enc, n, err, len(rs))
+		}
+		if !reflect.DeepEqual(data, rs) {
+			t.Errorf("encoding %d: decoded %v, want %v", enc, data, rs)
+		}
+		if _, err := decodeBlock(enc, []byte{0xFF, 0xFF, 0xFF}, data); err != errBadBlock {
+			t.Errorf("encoding %d: decodeBlock(garbage)=_,%v, want _,%v", enc, err, errBadBlock)
+		}
+	}
+}
+
+func TestBlockEncodingEdits(t *testing.T) {
+	for _, enc := range blockEncodings {
+		rand.Seed(0)
+		b := NewBuffer(testBlockSize)
+		defer b.Close()
+		b.SetBlockEncoding(enc)
+		var want []rune
+		for i := 0; i < 500; i++ {
+			at := rand.Intn(len(want) + 1)
+			if rand.Intn(3) == 0 && at < len(want) {
+				m := rand.Intn(len(want)-at) + 1
+				if err := b.Delete(int64(m), int64(at)); err != nil {
+					t.Fatalf("encoding %d: b.Delete(%d, %d)=%v, want nil", enc, m, at, err)
+				}
+				want = append(want[:at], want[at+m:]...)
+				continue
+			}
+			rs := []rune(randomString(rand.Intn(2 * testBlockSize)))
+			if err := b.Insert(rs, int64(at)); err != nil {
+				t.Fatalf("encoding %d: b.Insert(%q, %d)=%v, want nil", enc, string(rs), at, err)
+			}
+			want = append(want[:at], append(rs, want[at:]...)...)
+			if i == 250 {
+				// Blocks written in one format can be read
+				// after the format changes.
+				b.SetBlockEncoding(blockEncodings[(int(enc)+1)%len(blockEncodings)])
+			}
+		}
+		if s := b.String(); s != string(want) {
+			t.Errorf("encoding %d: b.String()=%q, want %q", enc, s, string(want))
+		}
+	}
+}
+
+func TestBlockEncodingSize(t *testing.T) {
+	const blockSize = 1024
+	str := strings.Repeat("Hello, World! ", 1000)
+	sizes := make(map[BlockEncoding]int64)
+	for _, enc := range blockEncodings {
+		f := tempFile(t)
+		defer os.Remove(f.Name())
+		b := NewBufferReaderWriterAt(blockSize, f)
+		b.SetBlockEncoding(enc)
+		if err := b.Insert([]rune(str), 0); err != nil {
+			t.Fatalf("encoding %d: b.Insert(…)=%v, want nil", enc, err)
+		}
+		if err := b.Compact(); err != nil {
+			t.Fatalf("encoding %d: b.Compact()=%v, want nil", enc, err)
+		}
+		if err := b.Sync(); err != nil {
+			t.Fatalf("encoding %d: b.Sync()=%v, want nil", enc, err)
+		}
+		sizes[enc] = b.end
+		f.Close()
+
+		r := openBuffer(t, f.Name())
+		if s := r.String(); s != str {
+			t.Errorf("encoding %d: reopened r.String()=%q, want %q", enc, s, str)
+		}
+		if r.enc != enc {
+			t.Errorf("encoding %d: reopened r.enc=%d", enc, r.enc)
+		}
+		r.Close()
+	}
+	if sizes[VarintBlocks] >= sizes[RawBlocks]/2 {
+		t.Errorf("varint size %d, raw size %d", sizes[VarintBlocks], sizes[RawBlocks])
+	}
+	if sizes[CompressedBlocks] >= sizes[VarintBlocks] {
+		t.Errorf("compressed size %d, varint size %d", sizes[CompressedBlocks], sizes[VarintBlocks])
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import "math/rand"
+
+// A blockTree is the sequence of blocks of a Buffer.
+// It finds, inserts, and removes blocks
+// by index or by rune offset in logarithmic time.
+//
+// The tree is a treap of blocks, ordered by their position in the Buffer.
+// Each block holds the number of blocks and runes in its subtree,
+// so the index and offset of a block is found
+// by summing along the path from the root.
+//
+// The tree is persistent: a copy of the tree shares its blocks,
+// and neither is changed by changes to the other.
+// Each block records the generation of the tree that created it.
+// Before a block from an older generation is changed, it is copied,
+// so incrementing the generation freezes all current blocks.
+type blockTree struct {
+	root *block
+	gen  int64
+}
+
+func (t *block) size() int {
+	if t == nil {
+		return 0
+	}
+	return t.count
+}
+
+func (t *block) total() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.sum
+}
+
+// Totals returns the lengths of t's subtree
+// in runes, UTF-8 bytes, and UTF-16 code units.
+func (t *block) totals() offset {
+	if t == nil {
+		return offset{}
+	}
+	return offset{t.sum, t.sum8, t.sum16}
+}
+
+// Lens returns the lengths of the block
+// in runes, UTF-8 bytes, and UTF-16 code units.
+func (t *block) lens() offset { return offset{int64(t.n), int64(t.u8), int64(t.u16)} }
+
+func (t *block) fix() *block {
+	t.count = t.left.size() + 1 + t.right.size()
+	t.sum = t.left.total() + int64(t.n) + t.right.total()
+	t.sum8 = t.left.totals().bytes + int64(t.u8) + t.right.totals().bytes
+	t.sum16 = t.left.totals().utf16 + int64(t.u16) + t.right.totals().utf16
+	return t
+}
+
+// Len returns the number of blocks.
+func (x *blockTree) len() int { return x.root.size() }
+
+// At returns the block at index i.
+// At panics if i is out of range.
+func (x *blockTree) at(i int) *block {
+	if i < 0 || i >= x.len() {
+		panic("block index out of range")
+	}
+	t := x.root
+	for {
+		switch l := t.left.size(); {
+		case i < l:
+			t = t.left
+		case i == l:
+			return t
+		default:
+			i -= l + 1
+			t = t.right
+		}
+	}
+}
+
+// Start returns the offset of the first rune of the block at index i.
+// Start panics if i is out of range.
+func (x *blockTree) start(i int) int64 {
+	if i < 0 || i >= x.len() {
+		panic("block index out of range")
+	}
+	var q0 int64
+	t := x.root
+	for {
+		switch l := t.left.size(); {
+		case i < l:
+			t = t.left
+		case i == l:
+			return q0 + t.left.total()
+		default:
+			i -= l + 1
+			q0 += t.left.total() + int64(t.n)
+			t = t.right
+		}
+	}
+}
+
+// Find returns the index and start offset
+// of the block containing the rune at offset p.
+// Find panics if p is out of range.
+func (x *blockTree) find(p int64) (int, int64) {
+	if p < 0 || p >= x.root.total() {
+		panic("block offset out of range")
+	}
+	var i int
+	var q0 int64
+	t := x.root
+	for {
+		switch l := t.left.total(); {
+		case p-q0 < l:
+			t = t.left
+		case p-q0 < l+int64(t.n):
+			return i + t.left.size(), q0 + l
+		default:
+			i += t.left.size() + 1
+			q0 += l + int64(t.n)
+			t = t.right
+		}
+	}
+}
+
+// Locate returns the index and start offset of the block
+// containing the position p in the units selected by unit.
+// Locate panics if p is out of range.
+func (x *blockTree) locate(p int64, unit func(offset) int64) (int, offset) {
+	if p < 0 || p >= unit(x.root.totals()) {
+		panic("block offset out of range")
+	}
+	var i int
+	var q0 offset
+	t := x.root
+	for {
+		l := t.left.totals()
+		switch {
+		case p-unit(q0) < unit(l):
+			t = t.left
+		case p-unit(q0) < unit(l.add(t.lens())):
+			return i + t.left.size(), q0.add(l)
+		default:
+			i += t.left.size() + 1
+			q0 = q0.add(l).add(t.lens())
+			t = t.right
+		}
+	}
+}
+
+// Insert inserts a new block at index i.
+func (x *blockTree) insert(i int, blk *block) {
+	blk.left, blk.right, blk.pri, blk.gen = nil, nil, rand.Int31(), x.gen
+	l, r := x.split(x.root, i)
+	x.root = x.merge(l, x.merge(blk.fix(), r))
+}
+
+// Remove removes and returns the block at index i.
+func (x *blockTree) remove(i int) *block {
+	l, r := x.split(x.root, i)
+	m, r := x.split(r, 1)
+	x.root = x.merge(l, r)
+	m.left, m.right = nil, nil
+	return m.fix()
+}
+
+// Mut returns the block at index i,
+// copying it and its ancestors if they are from an older generation.
+func (x *blockTree) mut(i int) *block {
+	var blk *block
+	x.root = x.walk(x.root, i, func(t *block) { blk = t })
+	return blk
+}
+
+// Grow adds d runes to the size of the block at index i,
+// and d8 and d16 to its UTF-8 and UTF-16 lengths.
+func (x *blockTree) grow(i int, d, d8, d16 int) {
+	x.root = x.walk(x.root, i, func(t *block) {
+		t.n += d
+		t.u8 += d8
+		t.u16 += d16
+	})
+}
+
+// Walk calls f on the block at index i of t and returns the new t.
+// The block and its ancestors are copied if they are from an older generation.
+func (x *blockTree) walk(t *block, i int, f func(*block)) *block {
+	t = x.own(t)
+	switch l := t.left.size(); {
+	case i < l:
+		t.left = x.walk(t.left, i, f)
+	case i == l:
+		f(t)
+	default:
+		t.right = x.walk(t.right, i-l-1, f)
+	}
+	return t.fix()
+}
+
+// Own returns t if it is from the current generation,
+// otherwise it returns a copy of t from the current generation.
+// The cached data of t, if any, is moved to the copy.
+func (x *blockTree) own(t *block) *block {
+	if t.gen == x.gen {
+		return t
+	}
+	c := new(block)
+	*c = *t
+	c.gen = x.gen
+	if t.elem != nil {
+		c.elem.Value = c
+		t.data, t.dirty, t.elem = nil, false, nil
+	}
+	return c
+}
+
+// Each calls f on each block in order.
+func (x *blockTree) each(f func(*block)) { eachBlock(x.root, f) }
+
+func eachBlock(t *block, f func(*block)) {
+	if t == nil {
+		return
+	}
+	eachBlock(t.left, f)
+	f(t)
+	eachBlock(t.right, f)
+}
+
+// Split returns the first k blocks of t and the remaining blocks.
+func (x *blockTree) split(t *block, k int) (*block, *block) {
+	if t == nil {
+		return nil, nil
+	}
+	t = x.own(t)
+	if k <= t.left.size() {
+		a, b := x.split(t.left, k)
+		t.left = b
+		return a, t.fix()
+	}
+	a, b := x.split(t.right, k-t.left.size()-1)
+	t.right = a
+	return t.fix(), b
+}
+
+// Merge returns the blocks of a followed by the blocks of b.
+func (x *blockTree) merge(a, b *block) *block {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.pri > b.pri:
+		a = x.own(a)
+		a.right = x.merge(a.right, b)
+		return a.fix()
+	default:
+		b = x.own(b)
+		b.left = x.merge(a, b.left)
+		return b.fix()
+	}
+}
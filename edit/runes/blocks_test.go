@@ -0,0 +1,64 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestBlockTree tests random operations on a blockTree
+// against a slice of blocks.
+func TestBlockTree(t *testing.T) {
+	rand.Seed(0)
+	var x blockTree
+	var want []*block
+	for i := 0; i < 2000; i++ {
+		switch op := rand.Intn(4); {
+		case op == 0 && len(want) > 0:
+			j := rand.Intn(len(want))
+			if blk := x.remove(j); blk != want[j] {
+				t.Fatalf("x.remove(%d)=%p, want %p", j, blk, want[j])
+			}
+			want = append(want[:j], want[j+1:]...)
+		case op == 1 && len(want) > 0:
+			j := rand.Intn(len(want))
+			d := rand.Intn(testBlockSize-want[j].n+1) - want[j].n
+			x.grow(j, d, 3*d, 2*d)
+		default:
+			j := rand.Intn(len(want) + 1)
+			n := rand.Intn(testBlockSize + 1)
+			blk := &block{start: int64(i), n: n, u8: 3 * n, u16: 2 * n}
+			x.insert(j, blk)
+			want = append(want[:j], append([]*block{blk}, want[j:]...)...)
+		}
+
+		if x.len() != len(want) {
+			t.Fatalf("x.len()=%d, want %d", x.len(), len(want))
+		}
+		var q0 int64
+		for j, blk := range want {
+			if b := x.at(j); b != blk {
+				t.Fatalf("x.at(%d)=%p, want %p", j, b, blk)
+			}
+			if s := x.start(j); s != q0 {
+				t.Fatalf("x.start(%d)=%d, want %d", j, s, q0)
+			}
+			if blk.n > 0 {
+				k := q0 + rand.Int63n(int64(blk.n))
+				if f, s := x.find(k); f != j || s != q0 {
+					t.Fatalf("x.find(%d)=%d,%d, want %d,%d", k, f, s, j, q0)
+				}
+				k8 := 3*q0 + rand.Int63n(int64(blk.u8))
+				w := offset{q0, 3 * q0, 2 * q0}
+				if f, s := x.locate(k8, byteUnit); f != j || s != w {
+					t.Fatalf("x.locate(%d, byteUnit)=%d,%v, want %d,%v", k8, f, s, j, w)
+				}
+			}
+			q0 += int64(blk.n)
+		}
+		if w := (offset{q0, 3 * q0, 2 * q0}); x.root.totals() != w {
+			t.Fatalf("x.root.totals()=%v, want %v", x.root.totals(), w)
+		}
+	}
+}
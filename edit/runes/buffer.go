@@ -1,40 +1,88 @@
 package runes
 
 import (
-	"encoding/binary"
+	"compress/flate"
+	"container/list"
 	"errors"
 	"io"
-	"io/ioutil"
 	"os"
 	"strconv"
+	"sync"
 )
 
 // RuneBytes is the number of bytes in Go's rune type.
 const runeBytes = 4
 
+// ErrModified is returned by a Reader, Writer, or ReaderFrom of a Buffer
+// if the Buffer was modified since it was created,
+// other than by the Writer or ReaderFrom itself.
+var ErrModified = errors.New("buffer modified")
+
 // A Buffer is an unbounded rune buffer backed by a file.
 type Buffer struct {
 	// F is the file that backs the buffer. It is created lazily.
 	f ReaderWriterAt
+	// NewStore creates the file that backs the buffer.
+	newStore func() (ReaderWriterAt, error)
+	// Temp is whether f was created by newStore.
+	// If so, its file, if any, is removed when the buffer is closed.
+	temp bool
 	// BlockSize is the maximum number of runes in a block.
 	blockSize int
 	// Blocks contains all blocks of the buffer in order.
-	// Free contains blocks that are free to be re-allocated.
-	blocks, free []block
+	blocks blockTree
 	// End is the byte offset of the end of the backing file.
 	end int64
 
-	// Cache is the index of the block whose data is currently cached.
-	cached int
+	// SnapLock guards free, snaps, deferred, synced, and syncGen,
+	// which are also used when a Snapshot is closed.
+	snapLock sync.Mutex
+	// Free contains space in the file
+	// that is free to be re-allocated.
+	free []span
+	// Snaps contains the generations of the open snapshots.
+	snaps map[int64]bool
+	// Deferred contains freed blocks that may be referenced by open snapshots,
+	// or by the index written by the most recent Sync.
+	deferred []deferredBlock
+	// Synced is whether the Buffer has been synced,
+	// and syncGen is the generation of the blocks
+	// in the index written by the most recent Sync.
+	// Like the blocks of a Snapshot, their space is not re-used
+	// until the next Sync, so the index remains valid
+	// if the Buffer is changed and the process exits.
+	synced  bool
+	syncGen int64
+
+	// Cached is the most recently used block, or nil.
+	cached *block
 	// Cached0 is the address of the first rune in the cached block.
 	cached0 int64
-	// Cache is the cached data.
-	cache []rune
-	// Dirty tracks whether the cached data has changed since it was read.
-	dirty bool
+	// LRU lists the cached blocks from most to least recently used.
+	lru *list.List
+	// CacheBlocks is the maximum number of blocks cached in memory.
+	cacheBlocks int
+	// Hits and misses count block lookups
+	// that were and were not satisfied by the cache.
+	hits, misses int64
 
 	// Size is the number of runes in the buffer.
 	size int64
+	// Version is incremented each time the buffer is modified.
+	version int64
+	// CompactRatio is the ratio of the file size to its minimal size
+	// above which the buffer is automatically compacted,
+	// or 0 if the buffer is not automatically compacted.
+	compactRatio float64
+
+	// Enc is the format of blocks written to the file.
+	enc BlockEncoding
+	// Zw is re-used to compress blocks.
+	zw *flate.Writer
+
+	// Persist is whether the backing store is kept when the buffer is closed.
+	// It is set by Sync and OpenBuffer.
+	persist bool
 }
 
 // A ReaderWriterAt implements the io.ReaderAt and io.WriterAt interfaces.
@@ -43,49 +91,99 @@ type ReaderWriterAt interface {
 	io.WriterAt
 }
 
+// A span is a region of space in the backing file.
+type span struct {
+	start int64
+	size  int
+}
+
 // A block describes a portion of the buffer and its location in the backing file.
 type block struct {
 	// Start is the byte offset of the block in the file.
 	start int64
-	// N is the number of runes in the block.
-	n int
+	// Space is the number of bytes allocated for the block in the file.
+	space int
+	// Nbytes is the number of bytes of the block's encoded data in the file,
+	// and enc is the format of the data.
+	nbytes int
+	enc    BlockEncoding
+	// Crc is the checksum of the block's encoded data.
+	crc uint32
+	// N is the number of runes in the block,
+	// and u8 and u16 are their lengths
+	// in UTF-8 bytes and UTF-16 code units.
+	n, u8, u16 int
+
+	// Data is the block's data if it is cached, or nil.
+	data []rune
+	// Dirty is whether the cached data has changed since it was read.
+	dirty bool
+	// Elem is the block's element in the LRU list if it is cached.
+	elem *list.Element
+	// StartGen is the blockTree generation
+	// in which the block's space in the file was allocated.
+	startGen int64
+
+	// Left and right are the block's children in the blockTree,
+	// and pri is its treap priority.
+	left, right *block
+	pri         int32
+	// Gen is the blockTree generation that created the block.
+	gen int64
+	// Count is the number of blocks in the block's subtree.
+	count int
+	// Sum is the number of runes in the block's subtree,
+	// and sum8 and sum16 are their lengths
+	// in UTF-8 bytes and UTF-16 code units.
+	sum, sum8, sum16 int64
 }
 
 // NewBuffer returns a new, empty buffer.
-// No more than blockSize runes are cached in memory.
+// By default, no more than blockSize runes are cached in memory;
+// see SetCacheBlocks.
 func NewBuffer(blockSize int) *Buffer {
 	return &Buffer{
-		blockSize: blockSize,
-		cached:    -1,
-		cache:     make([]rune, blockSize),
+		blockSize:   blockSize,
+		end:         headerBytes,
+		lru:         list.New(),
+		cacheBlocks: 1,
+		newStore:    newTempFile,
 	}
 }
 
 // NewBufferReaderWriterAt is like NewBuffer but uses
 // the given ReaderWriterAt as its backing store.
 // If the ReaderWriterAt implements io.Closer, it is closed when the buffer is closed.
-// If the ReaderWriterAt is an *os.File, the file is removed when the buffer is closed.
+// The ReaderWriterAt's file, if any, is not removed.
 func NewBufferReaderWriterAt(blockSize int, f ReaderWriterAt) *Buffer {
 	b := NewBuffer(blockSize)
 	b.f = f
 	return b
 }
 
-// Close closes the buffer and removes it's backing file.
+// Close closes the buffer and removes it's backing file
+// if the file was created by the buffer.
+// If the buffer has been synced or was returned by OpenBuffer,
+// it is synced and the backing file is not removed.
 func (b *Buffer) Close() error {
-	b.cache = nil
-	switch f := b.f.(type) {
-	case *os.File:
-		path := f.Name()
-		if err := f.Close(); err != nil {
+	if b.persist {
+		if err := b.Sync(); err != nil {
 			return err
 		}
-		return os.Remove(path)
-	case io.Closer:
-		return f.Close()
-	default:
+	}
+	b.uncacheAll()
+	f, ok := b.f.(io.Closer)
+	if !ok {
 		return nil
 	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// Only a temporary file created by the Buffer is removed.
+	if n, ok := b.f.(interface{ Name() string }); ok && b.temp && !b.persist {
+		return os.Remove(n.Name())
+	}
+	return nil
 }
 
 // Size returns the number of runes in the buffer.
@@ -97,14 +195,18 @@ func (b *Buffer) Rune(offs int64) (rune, error) {
 	if offs < 0 || offs > b.Size() {
 		panic("rune index out of bounds")
 	}
-	if q0 := b.cached0; q0 <= offs && offs < q0+int64(b.blocks[b.cached].n) {
-		return b.cache[offs-q0], nil
+	if blk := b.cached; blk != nil && blk.data != nil {
+		if q0 := b.cached0; q0 <= offs && offs < q0+int64(blk.n) {
+			b.hits++
+			return blk.data[offs-q0], nil
+		}
 	}
 	i, q0 := b.blockAt(offs)
-	if _, err := b.get(i); err != nil {
+	blk, err := b.get(i)
+	if err != nil {
 		return -1, err
 	}
-	return b.cache[offs-q0], nil
+	return blk.data[offs-q0], nil
 }
 
 // Read reads runes from the buffer beginning at a given offset.
@@ -117,7 +219,8 @@ func (b *Buffer) Read(n int, offs int64) ([]rune, error) {
 
 type reader struct {
 	*Buffer
-	pos int64
+	pos     int64
+	version int64
 }
 
 // Len returns the number of runes in the unread portion of the reader.
@@ -126,11 +229,17 @@ func (r *reader) Len() int64 { return r.Size() - r.pos }
 // Reader returns a Reader that reads from the Buffer
 // beginning at the given offset.
 // The returned Reader need not be closed.
-// The Buffer must not be modified
-// between Read calls on the returned Reader.
-func (b *Buffer) Reader(offs int64) Reader { return &reader{Buffer: b, pos: offs} }
+// If the Buffer is modified after the Reader is created,
+// Read returns ErrModified.
+// To read while the Buffer is modified, use a Snapshot.
+func (b *Buffer) Reader(offs int64) Reader {
+	return &reader{Buffer: b, pos: offs, version: b.version}
+}
 
 func (r *reader) Read(p []rune) (int, error) {
+	if r.version != r.Buffer.version {
+		return 0, ErrModified
+	}
 	if r.pos < 0 || r.pos > r.Size() {
 		return 0, os.ErrInvalid
 	}
@@ -143,7 +252,7 @@ func (r *reader) Read(p []rune) (int, error) {
 		return 0, err
 	}
 	cacheOffs := int(r.pos - blkStart)
-	n := copy(p, r.cache[cacheOffs:blk.n])
+	n := copy(p, blk.data[cacheOffs:blk.n])
 	r.pos += int64(n)
 	return n, nil
 }
@@ -158,11 +267,17 @@ func (b *Buffer) Insert(p []rune, offs int64) error {
 // Writer returns a Writer that inserts into the Buffer
 // beginning at the given offset.
 // The returned Writer need not be closed.
-func (b *Buffer) Writer(offs int64) Writer { return &writer{Buffer: b, pos: offs} }
+// If the Buffer is modified after the Writer is created,
+// other than by the Writer itself,
+// Write returns ErrModified.
+func (b *Buffer) Writer(offs int64) Writer {
+	return &writer{Buffer: b, pos: offs, version: b.version}
+}
 
 type writer struct {
 	*Buffer
-	pos int64
+	pos     int64
+	version int64
 }
 
 func (w *writer) Write(p []rune) (int, error) {
@@ -172,59 +287,101 @@ func (w *writer) Write(p []rune) (int, error) {
 }
 
 func (w *writer) ReadFrom(r Reader) (int64, error) {
+	if w.version != w.Buffer.version {
+		return 0, ErrModified
+	}
+	defer func() { w.version = w.Buffer.version }()
 	return w.Buffer.ReaderFrom(w.pos).ReadFrom(r)
 }
 
 // ReaderFrom returns a ReaderFrom that inserts into the Buffer
 // beginning at the given offset.
+// If the Buffer is modified after the ReaderFrom is created,
+// other than by the ReaderFrom itself,
+// ReadFrom returns ErrModified.
 func (b *Buffer) ReaderFrom(offs int64) ReaderFrom {
-	return &readerFrom{Buffer: b, pos: offs}
+	return &readerFrom{Buffer: b, pos: offs, version: b.version}
 }
 
 type readerFrom struct {
 	*Buffer
-	pos int64
+	pos     int64
+	version int64
 }
 
 func (dst *readerFrom) ReadFrom(r Reader) (int64, error) {
+	if dst.version != dst.Buffer.version {
+		return 0, ErrModified
+	}
+	defer func() { dst.version = dst.Buffer.version }()
 	if dst.pos < 0 || dst.pos > dst.Size() {
 		return 0, os.ErrInvalid
 	}
+	var n int64
+	var err error
 	if sz, ok := readLen(r); ok {
-		return fastReadFrom(dst, r, sz)
+		n, err = fastReadFrom(dst, r, sz)
+	} else {
+		n, err = slowCopy(dst.Buffer.Writer(dst.pos), r)
 	}
-	return slowCopy(dst.Buffer.Writer(dst.pos), r)
+	if err == nil {
+		err = dst.autoCompact()
+	}
+	return n, err
 }
 
 func fastReadFrom(dst *readerFrom, r Reader, sz int64) (int64, error) {
 	var tot int64
 	for tot < sz {
-		p, err := dst.makeSpace(sz-tot, dst.pos+tot)
+		i, p, err := dst.makeSpace(sz-tot, dst.pos+tot)
 		if err != nil {
 			return tot, err
 		}
 		n, err := readFull(r, p)
+		u8, u16 := measure(p)
+		dst.blocks.grow(i, 0, u8, u16)
 		tot += int64(n)
+		if n < len(p) {
+			// The reader ended early; remove the space left unread.
+			if err := dst.Delete(int64(len(p)-n), dst.pos+tot); err != nil {
+				return tot, err
+			}
+		}
+		if err == io.EOF {
+			return tot, nil
+		}
 		if err != nil {
 			return tot, err
 		}
 	}
-	// Sanity check: no more to read.
-	if n, err := r.Read(make([]rune, 1)); n != 0 || err != io.EOF {
-		panic("more to read")
+	// The reader may have more runes than its length.
+	var more [1]rune
+	n, err := r.Read(more[:])
+	if n > 0 {
+		if err := dst.Insert(more[:n], dst.pos+tot); err != nil {
+			return tot, err
+		}
+		tot += int64(n)
 	}
-	return tot, nil
+	switch {
+	case err == io.EOF:
+		return tot, nil
+	case err != nil:
+		return tot, err
+	}
+	m, err := slowCopy(dst.Buffer.Writer(dst.pos+tot), r)
+	return tot + m, err
 }
 
+// ReadFull reads from r until p is full,
+// returning the number of runes read.
+// The error is io.EOF if r ends before p is full.
 func readFull(r Reader, p []rune) (int, error) {
 	var tot int
 	for tot < len(p) {
 		n, err := r.Read(p[tot:])
 		tot += n
 		if err != nil {
-			if err == io.EOF {
-				panic("not enough to read")
-			}
 			return tot, err
 		}
 	}
@@ -253,21 +410,24 @@ func readLen(r Reader) (int64, bool) {
 
 // MakeSpace makes space in the buffer
 // for up to n runes at the given address
-// and returns a slice of the cache
-// corresponding to the space.
-func (b *Buffer) makeSpace(n, at int64) ([]rune, error) {
+// and returns the index of the block containing the space
+// and a slice of the cache corresponding to the space.
+// The UTF-8 and UTF-16 lengths of the block
+// do not include the space until it is filled
+// and the lengths are grown to include it.
+func (b *Buffer) makeSpace(n, at int64) (int, []rune, error) {
 	i, blkStart := b.blockAt(at)
 	blk, err := b.get(i)
 	if err != nil {
-		return nil, err
+		return -1, nil, err
 	}
 	blkSpace := b.blockSize - blk.n
 	if blkSpace == 0 {
 		if i, err = b.insertAt(at); err != nil {
-			return nil, err
+			return -1, nil, err
 		}
 		if blk, err = b.get(i); err != nil {
-			return nil, err
+			return -1, nil, err
 		}
 		blkStart = at
 		blkSpace = b.blockSize
@@ -276,11 +436,12 @@ func (b *Buffer) makeSpace(n, at int64) ([]rune, error) {
 		blkSpace = int(n)
 	}
 	cacheOffs := int(at - blkStart)
-	copy(b.cache[cacheOffs+blkSpace:], b.cache[cacheOffs:blk.n])
-	b.dirty = true
-	blk.n += blkSpace
+	blk = b.modify(i)
+	copy(blk.data[cacheOffs+blkSpace:], blk.data[cacheOffs:blk.n])
+	b.blocks.grow(i, blkSpace, 0, 0)
 	b.size += int64(blkSpace)
-	return b.cache[cacheOffs : cacheOffs+blkSpace], nil
+	b.version++
+	return i, blk.data[cacheOffs : cacheOffs+blkSpace], nil
 }
 
 // Delete deletes runes from the buffer starting at the given offset.
@@ -305,34 +466,63 @@ func (b *Buffer) Delete(n, offs int64) error {
 		}
 		if o == 0 && n >= int64(blk.n) {
 			// Remove the entire block.
-			b.freeBlock(*blk)
-			b.blocks = append(b.blocks[:i], b.blocks[i+1:]...)
-			b.cached = -1
+			b.freeBlock(b.blocks.remove(i))
 		} else {
 			// Remove a portion of the block.
-			copy(b.cache[o:], b.cache[o+m:])
-			b.dirty = true
-			blk.n -= m
+			blk = b.modify(i)
+			u8, u16 := measure(blk.data[o : o+m])
+			copy(blk.data[o:], blk.data[o+m:blk.n])
+			b.blocks.grow(i, -m, -u8, -u16)
 		}
 		n -= int64(m)
 		b.size -= int64(m)
+		b.version++
 	}
-	return nil
+	return b.autoCompact()
 }
 
-func (b *Buffer) allocBlock() block {
-	if l := len(b.free); l > 0 {
-		blk := b.free[l-1]
-		b.free = b.free[:l-1]
-		return blk
+// AllocBlock returns a new, empty block
+// with newly allocated space in the file.
+func (b *Buffer) allocBlock() *block {
+	space := b.spaceFor(b.blockSize)
+	return &block{start: b.allocSpan(space), space: space, startGen: b.blocks.gen}
+}
+
+// AllocSpan returns the start of newly allocated space in the file.
+func (b *Buffer) allocSpan(size int) int64 {
+	b.snapLock.Lock()
+	defer b.snapLock.Unlock()
+	for i := len(b.free) - 1; i >= 0; i-- {
+		if sp := b.free[i]; sp.size == size {
+			l := len(b.free) - 1
+			b.free[i] = b.free[l]
+			b.free = b.free[:l]
+			return sp.start
+		}
 	}
-	blk := block{start: b.end}
-	b.end += int64(b.blockSize * runeBytes)
-	return blk
+	start := b.end
+	b.end += int64(size)
+	return start
 }
 
-func (b *Buffer) freeBlock(blk block) {
-	b.free = append(b.free, block{start: blk.start})
+// FreeBlock removes a block from the cache and frees its space in the file.
+func (b *Buffer) freeBlock(blk *block) {
+	b.uncache(blk)
+	b.freeSpan(span{start: blk.start, size: blk.space}, blk.startGen)
+}
+
+// Modify prepares the cached block at index i to be changed,
+// marking it dirty and returning it.
+// If the block may be referenced by a snapshot,
+// it is copied and given new space in the file.
+func (b *Buffer) modify(i int) *block {
+	blk := b.blocks.mut(i)
+	if blk.startGen < b.blocks.gen {
+		b.freeSpan(span{start: blk.start, size: blk.space}, blk.startGen)
+		blk.start, blk.startGen = b.allocSpan(blk.space), b.blocks.gen
+	}
+	blk.dirty = true
+	return blk
 }
 
 // BlockAt returns the index and start address of the block containing the address.
@@ -343,19 +533,11 @@ func (b *Buffer) blockAt(at int64) (int, int64) {
 		panic("invalid offset: " + strconv.FormatInt(at, 10))
 	}
 	if at == b.Size() {
-		i := len(b.blocks)
-		blk := b.allocBlock()
-		b.blocks = append(b.blocks[:i], append([]block{blk}, b.blocks[i:]...)...)
+		i := b.blocks.len()
+		b.blocks.insert(i, b.allocBlock())
 		return i, at
 	}
-	var q0 int64
-	for i, blk := range b.blocks {
-		if q0 <= at && at < q0+int64(blk.n) {
-			return i, q0
-		}
-		q0 += int64(blk.n)
-	}
-	panic("impossible")
+	return b.blocks.find(at)
 }
 
 // insertAt inserts a block at the address and returns the new block's index.
@@ -363,112 +545,157 @@ func (b *Buffer) blockAt(at int64) (int, int64) {
 func (b *Buffer) insertAt(at int64) (int, error) {
 	i, q0 := b.blockAt(at)
 	o := int(at - q0)
-	blk := b.blocks[i]
 	if at == q0 {
 		// Adding immediately before blk, no need to split.
-		nblk := b.allocBlock()
-		b.blocks = append(b.blocks[:i], append([]block{nblk}, b.blocks[i:]...)...)
-		if b.cached == i {
-			b.cached = i + 1
-		}
+		b.blocks.insert(i, b.allocBlock())
 		return i, nil
 	}
 
 	// Splitting blk.
 	// Make sure it's both on disk and in the cache.
-	if b.cached == i && b.dirty {
-		if err := b.put(); err != nil {
-			return -1, err
-		}
-	} else if _, err := b.get(i); err != nil {
+	blk, err := b.get(i)
+	if err != nil {
+		return -1, err
+	}
+	if err := b.put(blk); err != nil {
 		return -1, err
 	}
 
-	// Resize blk.
-	b.blocks[i].n = int(o)
+	// Resize blk, and move its cached data
+	// to a new block for its second half.
+	// The new block is dirty, so it will be written
+	// when it is evicted from the cache.
+	n := blk.n
+	data := blk.data
+	u8, u16 := measure(data[o:n])
+	b.uncache(blk)
+	b.blocks.grow(i, o-n, -u8, -u16)
+	copy(data, data[o:n])
 
 	// Insert the new, empty block.
-	nblk := b.allocBlock()
-	b.blocks = append(b.blocks[:i+1], append([]block{nblk}, b.blocks[i+1:]...)...)
+	b.blocks.insert(i+1, b.allocBlock())
 
-	// Allocate a block for the second half of blk and set it as the cache.
-	// The next put will write it out.
-	nblk = b.allocBlock()
-	b.blocks = append(b.blocks[:i+2], append([]block{nblk}, b.blocks[i+2:]...)...)
-	b.blocks[i+2].n = blk.n - o
-	copy(b.cache, b.cache[o:])
-	b.cached = i + 2
-	b.dirty = true
+	// Insert the block for the second half of blk.
+	nblk := b.allocBlock()
+	nblk.n, nblk.u8, nblk.u16 = n-o, u8, u16
+	b.blocks.insert(i+2, nblk)
+	b.cache(nblk, data)
+	nblk.dirty = true
+	b.cached = nblk
+	b.cached0 = at
 
 	return i + 1, nil
 }
 
-// File returns an *os.File, creating a new file if one is not created yet.
+// File returns the backing store, creating it if it is not created yet.
 func (b *Buffer) file() (ReaderWriterAt, error) {
 	if b.f == nil {
-		f, err := ioutil.TempFile(os.TempDir(), "edit")
+		f, err := b.newStore()
 		if err != nil {
 			return nil, err
 		}
-		b.f = f
+		b.f, b.temp = f, true
 	}
 	return b.f, nil
 }
 
-// Put writes the cached block back to the file.
-func (b *Buffer) put() error {
-	if b.cached < 0 || !b.dirty || len(b.cache) == 0 {
+// Put writes a cached block back to the file if it is dirty.
+// If the encoded block needs a different amount of space,
+// the block is moved to newly allocated space.
+func (b *Buffer) put(blk *block) error {
+	if !blk.dirty {
 		return nil
 	}
-	blk := b.blocks[b.cached]
 	f, err := b.file()
 	if err != nil {
 		return err
 	}
-	bs := make([]byte, blk.n*runeBytes)
-	for i, r := range b.cache[:blk.n] {
-		binary.LittleEndian.PutUint32(bs[i*runeBytes:], uint32(r))
+	bs, err := b.encode(blk.data[:blk.n])
+	if err != nil {
+		return err
+	}
+	if space := b.spaceFor(len(bs)); space != blk.space {
+		b.freeSpan(span{start: blk.start, size: blk.space}, blk.startGen)
+		blk.start, blk.space, blk.startGen = b.allocSpan(space), space, b.blocks.gen
 	}
 	if _, err := f.WriteAt(bs, blk.start); err != nil {
 		return err
 	}
-	b.dirty = false
+	blk.nbytes, blk.enc, blk.crc = len(bs), b.enc, checksum(bs)
+	blk.dirty = false
 	return nil
 }
 
-// Get loads the cache with the data from the block at the given index,
-// returning a pointer to it.
+// Get returns the block at the given index,
+// loading its data into the cache if it is not already cached.
 func (b *Buffer) get(i int) (*block, error) {
-	if b.cached == i {
-		return &b.blocks[i], nil
+	blk := b.blocks.at(i)
+	if blk.data != nil {
+		b.hits++
+		b.lru.MoveToFront(blk.elem)
+	} else {
+		b.misses++
+		if err := b.load(blk); err == errBadBlock {
+			return nil, corruptBlock(&b.blocks, i)
+		} else if err != nil {
+			return nil, err
+		}
 	}
-	if err := b.put(); err != nil {
-		return nil, err
+	if b.cached != blk {
+		b.cached = blk
+		b.cached0 = b.blocks.start(i)
 	}
+	return blk, nil
+}
 
-	blk := b.blocks[i]
+// Load reads a block's data from the file into the cache,
+// evicting the least recently used block if the cache is full.
+func (b *Buffer) load(blk *block) error {
+	var data []rune
+	if b.lru.Len() >= b.cacheBlocks {
+		var err error
+		if data, err = b.evict(); err != nil {
+			return err
+		}
+	} else {
+		data = make([]rune, b.blockSize)
+	}
 	f, err := b.file()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	bs := make([]byte, blk.n*runeBytes)
-	if _, err := f.ReadAt(bs, blk.start); err != nil {
-		if err == io.EOF {
-			panic("unexpected EOF")
-		}
-		return nil, err
+	if err := readBlock(f, blk, data); err != nil {
+		return err
+	}
+	b.cache(blk, data)
+	return nil
+}
+
+// ReadBlock reads the data of a block from the file.
+// If the data is truncated, does not match its checksum,
+// or cannot be decoded, errBadBlock is returned.
+func readBlock(f io.ReaderAt, blk *block, data []rune) error {
+	if blk.n == 0 {
+		return nil
 	}
-	j := 0
-	for len(bs) > 0 {
-		b.cache[j] = rune(binary.LittleEndian.Uint32(bs))
-		bs = bs[runeBytes:]
-		j++
+	// Raw blocks may have been shortened since they were written,
+	// but the checksum covers all of the bytes that were written.
+	bs := make([]byte, blk.nbytes)
+	switch err := readAt(f, bs, blk.start); {
+	case err == io.ErrUnexpectedEOF:
+		return errBadBlock
+	case err != nil:
+		return err
 	}
-	b.cached = i
-	b.dirty = false
-	b.cached0 = 0
-	for j := 0; j < i; j++ {
-		b.cached0 += int64(b.blocks[j].n)
+	if checksum(bs) != blk.crc {
+		return errBadBlock
 	}
-	return &b.blocks[i], nil
+	n, err := decodeBlock(blk.enc, bs, data)
+	if err != nil {
+		return err
+	}
+	if n < blk.n {
+		return errBadBlock
+	}
+	return nil
 }
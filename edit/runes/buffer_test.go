@@ -232,6 +232,35 @@ func TestReaderFromFastPathShortReads(t *testing.T) {
 	}
 }
 
+// wrongLenReader is a Reader with a Len method
+// that returns the wrong number of runes.
+type wrongLenReader struct {
+	Reader
+	n int64
+}
+
+func (r *wrongLenReader) Len() int64 { return r.n }
+
+// Test that the ReaderFrom fast path handles Readers
+// with more or fewer runes than their Len.
+func TestReaderFromFastPathWrongLen(t *testing.T) {
+	const add = "Hello, 世界!"
+	for _, n := range []int64{0, 1, testBlockSize, int64(len([]rune(add))), 3 * testBlockSize} {
+		b := NewBuffer(testBlockSize)
+		defer b.Close()
+		if err := b.Insert([]rune("abcdef"), 0); err != nil {
+			t.Fatalf("b.Insert(abcdef, 0)=%v, want nil", err)
+		}
+		r := &wrongLenReader{Reader: StringReader(add), n: n}
+		if m, err := b.ReaderFrom(3).ReadFrom(r); m != int64(len([]rune(add))) || err != nil {
+			t.Errorf("Len()=%d: b.ReaderFrom(3).ReadFrom(…)=%d,%v, want %d,nil", n, m, err, len([]rune(add)))
+		}
+		if s, want := b.String(), "abc"+add+"def"; s != want {
+			t.Errorf("Len()=%d: b.String()=%q, want %q", n, s, want)
+		}
+	}
+}
+
 func TestDelete(t *testing.T) {
 	tests := []struct {
 		n, at int64
@@ -299,15 +328,15 @@ func TestBlockAlloc(t *testing.T) {
 	if err := b.Insert(rs, 0); err != nil {
 		t.Fatalf(`Initial insert(%v, 0)%v, wantnil`, rs, err)
 	}
-	if len(b.blocks) != 2 {
-		t.Fatalf("After initial insert: len(b.blocks)=%v, want 2", len(b.blocks))
+	if b.blocks.len() != 2 {
+		t.Fatalf("After initial insert: b.blocks.len()=%v, want 2", b.blocks.len())
 	}
 
 	if err := b.Delete(int64(l), 0); err != nil {
 		t.Fatalf(`Delete(%v, 0)=%v, want nil`, l, err)
 	}
-	if len(b.blocks) != 0 {
-		t.Fatalf("After delete: len(b.blocks)=%v, want 0", len(b.blocks))
+	if b.blocks.len() != 0 {
+		t.Fatalf("After delete: b.blocks.len()=%v, want 0", b.blocks.len())
 	}
 	if len(b.free) != 2 {
 		t.Fatalf("After delete: len(b.free)=%v, want 2", len(b.free))
@@ -319,8 +348,8 @@ func TestBlockAlloc(t *testing.T) {
 	if err := b.Insert(rs, 0); err != nil {
 		t.Fatalf(`Second insert(%v, 7)=%v, want nil`, rs, err)
 	}
-	if len(b.blocks) != 1 {
-		t.Fatalf("After second insert: len(b.blocks)=%d, want 1", len(b.blocks))
+	if b.blocks.len() != 1 {
+		t.Fatalf("After second insert: b.blocks.len()=%d, want 1", b.blocks.len())
 	}
 	if len(b.free) != 1 {
 		t.Fatalf("After second insert: len(b.free)=%d, want 1", len(b.free))
@@ -380,9 +409,9 @@ func makeTestBytes(t *testing.T) *Buffer {
 		b.Close()
 		t.Fatalf(`insert("!@#", 12)=%v, want nil`, err)
 	}
-	ns := make([]int, len(b.blocks))
-	for i, blk := range b.blocks {
-		ns[i] = blk.n
+	ns := make([]int, b.blocks.len())
+	for i := range ns {
+		ns[i] = b.blocks.at(i).n
 	}
 	if !reflect.DeepEqual(ns, []int{8, 4, 3, 4, 8}) {
 		b.Close()
@@ -430,3 +459,46 @@ func TestErrors(t *testing.T) {
 		t.Errorf("b.Close()=%v, want %v", err, f.error)
 	}
 }
+
+func TestModified(t *testing.T) {
+	b := makeTestBytes(t)
+	defer b.Close()
+
+	r := b.Reader(0)
+	p := make([]rune, 4)
+	if n, err := r.Read(p); n != 4 || err != nil {
+		t.Fatalf("r.Read(…)=%d,%v, want 4,nil", n, err)
+	}
+	w := b.Writer(0)
+	if _, err := w.Write([]rune("abc")); err != nil {
+		t.Fatalf("w.Write(…)=_,%v, want _,nil", err)
+	}
+	// The Writer is unaffected by its own writes.
+	if _, err := w.Write([]rune("def")); err != nil {
+		t.Fatalf("w.Write(…)=_,%v, want _,nil", err)
+	}
+	if n, err := r.Read(p); n != 0 || err != ErrModified {
+		t.Errorf("r.Read(…) after insert=%d,%v, want 0,%v", n, err, ErrModified)
+	}
+
+	rf := b.ReaderFrom(0)
+	if err := b.Delete(1, 0); err != nil {
+		t.Fatalf("b.Delete(1, 0)=%v, want nil", err)
+	}
+	if _, err := w.Write([]rune("ghi")); err != ErrModified {
+		t.Errorf("w.Write(…) after delete=_,%v, want _,%v", err, ErrModified)
+	}
+	if _, err := rf.ReadFrom(StringReader("xyz")); err != ErrModified {
+		t.Errorf("rf.ReadFrom(…) after delete=_,%v, want _,%v", err, ErrModified)
+	}
+
+	// A new reader reads the current contents.
+	if s := b.String(); s != "bcdef01234567abcd!@#efghSTUVWXYZ" {
+		t.Errorf("b.String()=%q, want %q", s, "bcdef01234567abcd!@#efghSTUVWXYZ")
+	}
+
+	// Copying a Buffer into itself would read modified data.
+	if _, err := Copy(b.Writer(0), b.Reader(0)); err != ErrModified {
+		t.Errorf("Copy(b.Writer(0), b.Reader(0))=_,%v, want _,%v", err, ErrModified)
+	}
+}
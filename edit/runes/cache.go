@@ -0,0 +1,76 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import "strconv"
+
+// SetCacheBlocks sets the maximum number of blocks
+// that are cached in memory.
+// Cached blocks are evicted in least-recently-used order,
+// and dirty blocks are written back to the backing store when evicted.
+// SetCacheBlocks panics if n is less than 1.
+func (b *Buffer) SetCacheBlocks(n int) error {
+	if n < 1 {
+		panic("bad cache size: " + strconv.Itoa(n))
+	}
+	b.cacheBlocks = n
+	for b.lru.Len() > n {
+		if _, err := b.evict(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CacheStats returns the number of block lookups
+// that were satisfied by the cache (hits)
+// and that had to read from the backing store (misses).
+func (b *Buffer) CacheStats() (hits, misses int64) { return b.hits, b.misses }
+
+// Cache adds a block with the given data to the front of the LRU list.
+func (b *Buffer) cache(blk *block, data []rune) {
+	blk.data = data
+	blk.dirty = false
+	blk.elem = b.lru.PushFront(blk)
+}
+
+// Uncache removes a block from the cache, discarding its data.
+func (b *Buffer) uncache(blk *block) {
+	if blk.elem == nil {
+		return
+	}
+	if b.cached == blk {
+		b.cached = nil
+	}
+	b.lru.Remove(blk.elem)
+	blk.data, blk.dirty, blk.elem = nil, false, nil
+}
+
+// UncacheAll removes all blocks from the cache, discarding their data.
+func (b *Buffer) uncacheAll() {
+	for b.lru.Len() > 0 {
+		b.uncache(b.lru.Back().Value.(*block))
+	}
+}
+
+// Evict writes back and removes the least recently used block from the cache,
+// and returns its data slice for re-use.
+func (b *Buffer) evict() ([]rune, error) {
+	blk := b.lru.Back().Value.(*block)
+	if err := b.put(blk); err != nil {
+		return nil, err
+	}
+	data := blk.data
+	b.uncache(blk)
+	return data, nil
+}
+
+// Flush writes back all dirty, cached blocks.
+func (b *Buffer) flush() error {
+	for e := b.lru.Front(); e != nil; e = e.Next() {
+		if err := b.put(e.Value.(*block)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
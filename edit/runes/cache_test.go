@@ -0,0 +1,103 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	b := makeTestBytes(t)
+	defer b.Close()
+	if err := b.SetCacheBlocks(2); err != nil {
+		t.Fatalf("b.SetCacheBlocks(2)=%v, want nil", err)
+	}
+	h0, m0 := b.CacheStats()
+
+	// Alternate between the first and last blocks.
+	// Only the first access of each misses.
+	for i := 0; i < 10; i++ {
+		for _, offs := range []int64{0, 26} {
+			if _, err := b.Rune(offs); err != nil {
+				t.Fatalf("b.Rune(%d)=_,%v, want _,nil", offs, err)
+			}
+		}
+	}
+	h, m := b.CacheStats()
+	if h-h0 != 18 || m-m0 != 2 {
+		t.Errorf("hits, misses=%d,%d, want 18,2", h-h0, m-m0)
+	}
+
+	// With only one cached block, every access misses.
+	if err := b.SetCacheBlocks(1); err != nil {
+		t.Fatalf("b.SetCacheBlocks(1)=%v, want nil", err)
+	}
+	h0, m0 = b.CacheStats()
+	for i := 0; i < 10; i++ {
+		for _, offs := range []int64{0, 26} {
+			if _, err := b.Rune(offs); err != nil {
+				t.Fatalf("b.Rune(%d)=_,%v, want _,nil", offs, err)
+			}
+		}
+	}
+	h, m = b.CacheStats()
+	if h-h0 != 0 || m-m0 != 20 {
+		t.Errorf("hits, misses=%d,%d, want 0,20", h-h0, m-m0)
+	}
+	if s := b.String(); s != "01234567abcd!@#efghSTUVWXYZ" {
+		t.Errorf("b.String()=%q, want %q", s, "01234567abcd!@#efghSTUVWXYZ")
+	}
+}
+
+// TestCacheWriteBack tests random edits with various cache sizes,
+// checking that dirty blocks are written back when evicted.
+func TestCacheWriteBack(t *testing.T) {
+	rand.Seed(0)
+	for _, n := range []int{1, 2, 3, 100} {
+		b := NewBuffer(testBlockSize)
+		defer b.Close()
+		if err := b.SetCacheBlocks(n); err != nil {
+			t.Fatalf("b.SetCacheBlocks(%d)=%v, want nil", n, err)
+		}
+		var want []rune
+		for i := 0; i < 500; i++ {
+			at := rand.Intn(len(want) + 1)
+			if rand.Intn(3) == 0 && at < len(want) {
+				m := rand.Intn(len(want)-at) + 1
+				if err := b.Delete(int64(m), int64(at)); err != nil {
+					t.Fatalf("b.Delete(%d, %d)=%v, want nil", m, at, err)
+				}
+				want = append(want[:at], want[at+m:]...)
+				continue
+			}
+			rs := []rune(randomString(rand.Intn(2 * testBlockSize)))
+			if err := b.Insert(rs, int64(at)); err != nil {
+				t.Fatalf("b.Insert(%q, %d)=%v, want nil", string(rs), at, err)
+			}
+			want = append(want[:at], append(rs, want[at:]...)...)
+		}
+		if b.lru.Len() > n {
+			t.Errorf("cache size %d: %d blocks cached", n, b.lru.Len())
+		}
+		if s := b.String(); s != string(want) {
+			t.Errorf("cache size %d: b.String()=%q, want %q", n, s, string(want))
+		}
+		if err := b.SetCacheBlocks(1); err != nil {
+			t.Fatalf("b.SetCacheBlocks(1)=%v, want nil", err)
+		}
+		if s := b.String(); s != string(want) {
+			t.Errorf("cache size %d: after shrink b.String()=%q, want %q", n, s, string(want))
+		}
+	}
+}
+
+func randomString(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz☺☹"
+	rs := []rune(letters)
+	s := make([]rune, n)
+	for i := range s {
+		s[i] = rs[rand.Intn(len(rs))]
+	}
+	return string(s)
+}
@@ -0,0 +1,75 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"hash/crc32"
+	"strconv"
+)
+
+// A CorruptBlockError is returned when a block read from the backing store
+// does not match its checksum or cannot be decoded.
+type CorruptBlockError struct {
+	// Block is the index of the corrupt block.
+	Block int
+	// Offset is the rune offset of the start of the block,
+	// and N is the number of runes in the block.
+	Offset int64
+	N      int
+}
+
+func (e *CorruptBlockError) Error() string {
+	return "corrupt block " + strconv.Itoa(e.Block) +
+		": runes [" + strconv.FormatInt(e.Offset, 10) +
+		", " + strconv.FormatInt(e.Offset+int64(e.N), 10) + ")"
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum returns the checksum of a block's encoded data.
+func checksum(bs []byte) uint32 {
+	return crc32.Checksum(bs, crcTable)
+}
+
+// CorruptBlock returns a *CorruptBlockError for the block at index i of t.
+func corruptBlock(t *blockTree, i int) error {
+	return &CorruptBlockError{Block: i, Offset: t.start(i), N: t.at(i).n}
+}
+
+// Verify checks the stored blocks of the Buffer against their checksums,
+// returning a *CorruptBlockError for the first that does not match.
+// Blocks that are cached in memory are not checked,
+// because their stored data will be overwritten before it is next read.
+func (b *Buffer) Verify() error {
+	return b.VerifyRange(b.Size(), 0)
+}
+
+// VerifyRange is like Verify, but it only checks the blocks
+// holding the n runes beginning at offs.
+// VerifyRange panics if the range is out of bounds.
+func (b *Buffer) VerifyRange(n, offs int64) error {
+	if n < 0 || offs < 0 || offs+n > b.Size() {
+		panic("rune index out of bounds")
+	}
+	if n == 0 {
+		return nil
+	}
+	f, err := b.file()
+	if err != nil {
+		return err
+	}
+	data := make([]rune, b.blockSize)
+	for i, q0 := b.blocks.find(offs); q0 < offs+n; i++ {
+		blk := b.blocks.at(i)
+		q0 += int64(blk.n)
+		if blk.data != nil {
+			continue
+		}
+		if err := readBlock(f, blk, data); err == errBadBlock {
+			return corruptBlock(&b.blocks, i)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
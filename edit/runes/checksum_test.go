@@ -0,0 +1,123 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCorruptBlock(t *testing.T) {
+	for _, enc := range blockEncodings {
+		f := tempFile(t)
+		defer os.Remove(f.Name())
+		b := NewBufferReaderWriterAt(testBlockSize, f)
+		b.SetBlockEncoding(enc)
+		if err := b.Insert([]rune("01234567abcdefghSTUVWXYZ"), 0); err != nil {
+			t.Fatalf("b.Insert(…)=%v, want nil", err)
+		}
+		if err := b.Verify(); err != nil {
+			t.Errorf("enc=%d: b.Verify()=%v, want nil", enc, err)
+		}
+
+		// Block 1 is not cached; flip a byte of its data.
+		blk := b.blocks.at(1)
+		if blk.data != nil {
+			t.Fatalf("enc=%d: block 1 is cached", enc)
+		}
+		var p [1]byte
+		if _, err := f.ReadAt(p[:], blk.start); err != nil {
+			t.Fatalf("f.ReadAt(…)=_,%v, want _,nil", err)
+		}
+		p[0] ^= 0xFF
+		if _, err := f.WriteAt(p[:], blk.start); err != nil {
+			t.Fatalf("f.WriteAt(…)=_,%v, want _,nil", err)
+		}
+
+		want := &CorruptBlockError{Block: 1, Offset: 8, N: 8}
+		if _, err := b.Rune(10); !reflect.DeepEqual(err, want) {
+			t.Errorf("enc=%d: b.Rune(10)=_,%v, want _,%v", enc, err, want)
+		}
+		if err := b.Verify(); !reflect.DeepEqual(err, want) {
+			t.Errorf("enc=%d: b.Verify()=%v, want %v", enc, err, want)
+		}
+		if err := b.VerifyRange(2, 7); !reflect.DeepEqual(err, want) {
+			t.Errorf("enc=%d: b.VerifyRange(2, 7)=%v, want %v", enc, err, want)
+		}
+		// Ranges that do not include block 1 are not checked.
+		if err := b.VerifyRange(8, 0); err != nil {
+			t.Errorf("enc=%d: b.VerifyRange(8, 0)=%v, want nil", enc, err)
+		}
+		if err := b.VerifyRange(0, 10); err != nil {
+			t.Errorf("enc=%d: b.VerifyRange(0, 10)=%v, want nil", enc, err)
+		}
+		if err := b.VerifyRange(8, 16); err != nil {
+			t.Errorf("enc=%d: b.VerifyRange(8, 16)=%v, want nil", enc, err)
+		}
+		// The other blocks are still readable.
+		if r, err := b.Rune(0); r != '0' || err != nil {
+			t.Errorf("enc=%d: b.Rune(0)=%q,%v, want '0',nil", enc, r, err)
+		}
+
+		s, err := b.Snapshot()
+		if err != nil {
+			t.Fatalf("b.Snapshot()=_,%v, want _,nil", err)
+		}
+		if _, err := s.Read(4, 6); !reflect.DeepEqual(err, want) {
+			t.Errorf("enc=%d: s.Read(4, 6)=_,%v, want _,%v", enc, err, want)
+		}
+		s.Close()
+		b.Close()
+	}
+}
+
+func TestTruncatedBlock(t *testing.T) {
+	f := tempFile(t)
+	defer os.Remove(f.Name())
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	defer b.Close()
+	if err := b.Insert([]rune("01234567abcdefghSTUVWXYZ"), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	if err := f.Truncate(b.blocks.at(1).start); err != nil {
+		t.Fatalf("f.Truncate(…)=%v, want nil", err)
+	}
+
+	want := &CorruptBlockError{Block: 1, Offset: 8, N: 8}
+	if _, err := b.Read(16, 0); !reflect.DeepEqual(err, want) {
+		t.Errorf("b.Read(16, 0)=_,%v, want _,%v", err, want)
+	}
+	if err := b.Verify(); !reflect.DeepEqual(err, want) {
+		t.Errorf("b.Verify()=%v, want %v", err, want)
+	}
+}
+
+func TestOpenBufferCorrupt(t *testing.T) {
+	f := tempFile(t)
+	path := f.Name()
+	defer os.Remove(path)
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	if err := b.Insert([]rune("01234567abcdefghSTUVWXYZ"), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	// The checksums are recorded in the index,
+	// so corruption is detected after reopening.
+	if _, err := f.WriteAt([]byte("garbage!"), b.blocks.at(2).start); err != nil {
+		t.Fatalf("f.WriteAt(…)=_,%v, want _,nil", err)
+	}
+	f.Close()
+
+	r := openBuffer(t, path)
+	defer r.Close()
+	want := &CorruptBlockError{Block: 2, Offset: 16, N: 8}
+	if _, err := r.Read(24, 0); !reflect.DeepEqual(err, want) {
+		t.Errorf("r.Read(24, 0)=_,%v, want _,%v", err, want)
+	}
+	if s := want.Error(); s != "corrupt block 2: runes [16, 24)" {
+		t.Errorf("want.Error()=%q, want %q", s, "corrupt block 2: runes [16, 24)")
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"errors"
+	"io"
+)
+
+// Compact merges underfull blocks and rewrites the backing file
+// to the minimal size needed to hold the contents of the Buffer.
+// If the backing store has a Truncate method, such as *os.File,
+// it is truncated to its new size.
+// If the Buffer has been synced, it is synced again after compacting.
+//
+// Compact returns an error if the Buffer has open Snapshots.
+// During compaction, the backing file temporarily grows
+// by up to the size of the Buffer's contents.
+// A synced Buffer is synced before its synced blocks are overwritten,
+// so its file remains valid if compaction is interrupted.
+func (b *Buffer) Compact() error {
+	b.snapLock.Lock()
+	nsnaps := len(b.snaps)
+	b.snapLock.Unlock()
+	if nsnaps > 0 {
+		return errors.New("cannot compact with open snapshots")
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	f, err := b.file()
+	if err != nil {
+		return err
+	}
+
+	// Write the contents in full blocks after the end of the file,
+	// then copy the blocks down to the beginning of the file.
+	// Each block is copied to an offset before its own,
+	// and before the end of the following block,
+	// so no block is overwritten before it is copied.
+	start := b.end
+	var blks []*block
+	var size int64
+	data := make([]rune, b.blockSize)
+	r := b.Reader(0)
+	for {
+		n, err := readBlockFull(r, data)
+		if n > 0 {
+			bs, err := b.encode(data[:n])
+			if err != nil {
+				return err
+			}
+			blk := &block{
+				start:  start + size,
+				space:  b.spaceFor(len(bs)),
+				nbytes: len(bs),
+				enc:    b.enc,
+				crc:    checksum(bs),
+				n:      n,
+			}
+			blk.u8, blk.u16 = measure(data[:n])
+			if _, err := f.WriteAt(bs, blk.start); err != nil {
+				return err
+			}
+			blks = append(blks, blk)
+			size += int64(blk.space)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.persist {
+		// The synced header references the blocks being overwritten.
+		// Sync the copy first, so the file remains valid
+		// if compaction is interrupted.
+		b.setBlocks(blks, start+size)
+		if err := b.Sync(); err != nil {
+			return err
+		}
+		// The synced copy and the index of the next Sync
+		// must not be overwritten by the copied-down blocks.
+		// If they would be, the copy is left in place.
+		if headerBytes+size+int64(indexBytes(len(blks), 0)) > start {
+			return nil
+		}
+	}
+
+	moved := make([]*block, len(blks))
+	size = 0
+	for i, blk := range blks {
+		bs := make([]byte, blk.nbytes)
+		if err := readAt(f, bs, blk.start); err != nil {
+			return err
+		}
+		m := *blk
+		m.start = headerBytes + size
+		if _, err := f.WriteAt(bs, m.start); err != nil {
+			return err
+		}
+		moved[i] = &m
+		size += int64(blk.space)
+	}
+	b.setBlocks(moved, headerBytes+size)
+
+	// A synced Buffer is synced before truncating,
+	// so the header never references truncated data.
+	if b.persist {
+		if err := b.Sync(); err != nil {
+			return err
+		}
+	}
+	if t, ok := f.(interface {
+		Truncate(int64) error
+	}); ok {
+		if err := t.Truncate(b.end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBlocks replaces the blocks of the Buffer
+// with the given blocks, which end at the given end of the file.
+// All other space in the file is forgotten.
+func (b *Buffer) setBlocks(blks []*block, end int64) {
+	b.uncacheAll()
+	b.blocks = blockTree{gen: b.blocks.gen}
+	for i, blk := range blks {
+		blk.startGen = b.blocks.gen
+		b.blocks.insert(i, blk)
+	}
+	b.end = end
+	b.snapLock.Lock()
+	b.free, b.deferred = nil, nil
+	b.synced = false
+	b.snapLock.Unlock()
+}
+
+// ReadBlockFull reads from r until data is full,
+// returning the number of runes read.
+// The error is io.EOF if r has no more runes.
+func readBlockFull(r Reader, data []rune) (int, error) {
+	var n int
+	for n < len(data) {
+		m, err := r.Read(data[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// SetCompactRatio sets the ratio of the size of the backing file
+// to its estimated minimal size above which
+// the Buffer is automatically compacted after it is modified.
+// A ratio of 0, the default, disables automatic compaction.
+// Automatic compaction is skipped while there are open Snapshots,
+// while the file wastes less than the space of 16 blocks,
+// and for a Buffer that has been synced,
+// because compacting it would sync its unsynced changes.
+// SetCompactRatio panics if the ratio is non-zero and not greater than 1.
+func (b *Buffer) SetCompactRatio(ratio float64) {
+	if ratio != 0 && ratio <= 1 {
+		panic("bad compact ratio")
+	}
+	b.compactRatio = ratio
+}
+
+// MinCompactBlocks is the number of blocks of wasted space
+// below which the Buffer is not automatically compacted,
+// regardless of the compact ratio.
+// It keeps small files from being rewritten after every change.
+// It must match the documentation of SetCompactRatio.
+const minCompactBlocks = 16
+
+// AutoCompact compacts the Buffer if its file is above the compact ratio.
+func (b *Buffer) autoCompact() error {
+	if b.compactRatio == 0 || b.persist {
+		return nil
+	}
+	// Estimate the minimal size as the number of full blocks
+	// needed to hold the contents, each using the space
+	// that is initially allocated for a block.
+	blockSize := int64(b.blockSize)
+	min := (b.size + blockSize - 1) / blockSize
+	if min == 0 {
+		min = 1
+	}
+	space := int64(b.spaceFor(b.blockSize))
+	min *= space
+	used := b.end - headerBytes
+	if float64(used) <= b.compactRatio*float64(min) || used-min < minCompactBlocks*space {
+		return nil
+	}
+	b.snapLock.Lock()
+	nsnaps := len(b.snaps)
+	b.snapLock.Unlock()
+	if nsnaps > 0 {
+		return nil
+	}
+	return b.Compact()
+}
@@ -0,0 +1,233 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// Fragment returns a buffer with many underfull blocks
+// and the expected contents of the buffer.
+func fragment(t *testing.T, b *Buffer) string {
+	rand.Seed(0)
+	var want []rune
+	for i := 0; i < 200; i++ {
+		at := rand.Intn(len(want) + 1)
+		if i%3 == 0 && at < len(want) {
+			m := rand.Intn(len(want)-at) + 1
+			if m > testBlockSize {
+				m = testBlockSize
+			}
+			if err := b.Delete(int64(m), int64(at)); err != nil {
+				t.Fatalf("b.Delete(%d, %d)=%v, want nil", m, at, err)
+			}
+			want = append(want[:at], want[at+m:]...)
+			continue
+		}
+		rs := []rune(randomString(rand.Intn(testBlockSize) + 1))
+		if err := b.Insert(rs, int64(at)); err != nil {
+			t.Fatalf("b.Insert(%q, %d)=%v, want nil", string(rs), at, err)
+		}
+		want = append(want[:at], append(rs, want[at:]...)...)
+	}
+	return string(want)
+}
+
+func TestCompact(t *testing.T) {
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	want := fragment(t, b)
+	nblks := (len([]rune(want)) + testBlockSize - 1) / testBlockSize
+	if n := b.blocks.len(); n <= nblks {
+		t.Fatalf("b.blocks.len()=%d, want >%d", n, nblks)
+	}
+
+	s, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("b.Snapshot()=_,%v, want _,nil", err)
+	}
+	if err := b.Compact(); err == nil {
+		t.Errorf("b.Compact() with open snapshot=nil, want error")
+	}
+	s.Close()
+
+	if err := b.Compact(); err != nil {
+		t.Fatalf("b.Compact()=%v, want nil", err)
+	}
+	if str := b.String(); str != want {
+		t.Errorf("b.String()=%q, want %q", str, want)
+	}
+	if n := b.blocks.len(); n != nblks {
+		t.Errorf("b.blocks.len()=%d, want %d", n, nblks)
+	}
+	if len(b.free) != 0 {
+		t.Errorf("len(b.free)=%d, want 0", len(b.free))
+	}
+	size := int64(headerBytes + nblks*testBlockSize*runeBytes)
+	if b.end != size {
+		t.Errorf("b.end=%d, want %d", b.end, size)
+	}
+	fi, err := b.f.(*os.File).Stat()
+	if err != nil {
+		t.Fatalf("Stat()=_,%v, want _,nil", err)
+	}
+	if fi.Size() != size {
+		t.Errorf("file size=%d, want %d", fi.Size(), size)
+	}
+
+	// The compacted buffer can still be edited.
+	if err := b.Insert([]rune("Hello, 世界!"), 3); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	want = string([]rune(want)[:3]) + "Hello, 世界!" + string([]rune(want)[3:])
+	if str := b.String(); str != want {
+		t.Errorf("b.String()=%q, want %q", str, want)
+	}
+}
+
+func TestCompactPersistent(t *testing.T) {
+	f := tempFile(t)
+	path := f.Name()
+	defer os.Remove(path)
+
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	want := fragment(t, b)
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	if err := b.Compact(); err != nil {
+		t.Fatalf("b.Compact()=%v, want nil", err)
+	}
+	r := openBuffer(t, path)
+	defer r.Close()
+	if str := r.String(); str != want {
+		t.Errorf("r.String()=%q, want %q", str, want)
+	}
+	nblks := (len([]rune(want)) + testBlockSize - 1) / testBlockSize
+	size := int64(headerBytes + nblks*testBlockSize*runeBytes + indexBytes(nblks, 0))
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat()=_,%v, want _,nil", err)
+	}
+	if fi.Size() != size {
+		t.Errorf("file size=%d, want %d", fi.Size(), size)
+	}
+	f.Close()
+}
+
+// A crashStore is a MemStore that fails all writes
+// after a given number of writes.
+type crashStore struct {
+	*MemStore
+	writes int
+}
+
+var errCrash = errors.New("crash")
+
+func (c *crashStore) WriteAt(p []byte, offs int64) (int, error) {
+	if c.writes == 0 {
+		return 0, errCrash
+	}
+	c.writes--
+	return c.MemStore.WriteAt(p, offs)
+}
+
+func (c *crashStore) Truncate(size int64) error {
+	if c.writes == 0 {
+		return errCrash
+	}
+	c.writes--
+	return c.MemStore.Truncate(size)
+}
+
+func TestCompactCrash(t *testing.T) {
+	for writes := 0; ; writes++ {
+		f := &crashStore{MemStore: &MemStore{}, writes: -1}
+		b := NewBufferReaderWriterAt(testBlockSize, f)
+		want := fragment(t, b)
+		if err := b.Sync(); err != nil {
+			t.Fatalf("b.Sync()=%v, want nil", err)
+		}
+
+		f.writes = writes
+		err := b.Compact()
+		if err != nil && err != errCrash {
+			t.Fatalf("b.Compact()=%v, want nil or %v", err, errCrash)
+		}
+		r, err2 := OpenBuffer(&MemStore{data: append([]byte{}, f.data...)})
+		if err2 != nil {
+			t.Fatalf("OpenBuffer after %d writes=_,%v, want _,nil", writes, err2)
+		}
+		if str := r.String(); str != want {
+			t.Fatalf("r.String() after %d writes=%q, want %q", writes, str, want)
+		}
+		if err == nil {
+			break
+		}
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	const ratio = 2
+	b.SetCompactRatio(ratio)
+	want := fragment(t, b)
+	if str := b.String(); str != want {
+		t.Errorf("b.String()=%q, want %q", str, want)
+	}
+	min := (len([]rune(want)) + testBlockSize - 1) / testBlockSize
+	if min == 0 {
+		min = 1
+	}
+	max := ratio * min
+	if max < min+minCompactBlocks {
+		max = min + minCompactBlocks
+	}
+	if n := (b.end - headerBytes) / (testBlockSize * runeBytes); n > int64(max) {
+		t.Errorf("%d blocks in file, want <= %d", n, max)
+	}
+}
+
+func TestAutoCompactMinWaste(t *testing.T) {
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	b.SetCompactRatio(2)
+	if err := b.Insert([]rune(randomString(4*testBlockSize)), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	end := b.end
+	// The file is 4 times its minimal size,
+	// but wastes fewer than minCompactBlocks blocks.
+	if err := b.Delete(3*testBlockSize, 0); err != nil {
+		t.Fatalf("b.Delete(…)=%v, want nil", err)
+	}
+	if b.end != end {
+		t.Errorf("b.end=%d after Delete, want %d", b.end, end)
+	}
+}
+
+func TestAutoCompactSynced(t *testing.T) {
+	f := &MemStore{}
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	defer b.Close()
+	b.SetCompactRatio(2)
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	want := fragment(t, b)
+	if str := b.String(); str != want {
+		t.Errorf("b.String()=%q, want %q", str, want)
+	}
+	// The changes are not synced by compaction.
+	r, err := OpenBuffer(&MemStore{data: append([]byte{}, f.data...)})
+	if err != nil {
+		t.Fatalf("OpenBuffer(…)=_,%v, want _,nil", err)
+	}
+	if str := r.String(); str != "" {
+		t.Errorf("r.String()=%q, want %q", str, "")
+	}
+}
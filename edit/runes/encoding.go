@@ -0,0 +1,349 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// An Encoding is a character encoding of text.
+//
+// Byte order marks are not treated specially by Readers and Writers:
+// a byte order mark is read as the rune U+FEFF,
+// and the rune U+FEFF is written as a byte order mark,
+// so a file read and written in the same encoding
+// keeps its byte order mark.
+type Encoding int
+
+const (
+	// UTF8 is the UTF-8 encoding.
+	UTF8 Encoding = iota
+	// UTF16LE is the little-endian UTF-16 encoding.
+	UTF16LE
+	// UTF16BE is the big-endian UTF-16 encoding.
+	UTF16BE
+	// Latin1 is the ISO-8859-1 encoding.
+	// Each byte is the rune of the same value.
+	Latin1
+	// Windows1252 is the Windows code page 1252 encoding.
+	// It is Latin1, but with printable characters
+	// in place of most of the C1 control codes, 0x80–0x9F.
+	// The five bytes that are undefined in code page 1252
+	// are the C1 control codes of the same value.
+	Windows1252
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case UTF8:
+		return "UTF-8"
+	case UTF16LE:
+		return "UTF-16LE"
+	case UTF16BE:
+		return "UTF-16BE"
+	case Latin1:
+		return "ISO-8859-1"
+	case Windows1252:
+		return "Windows-1252"
+	default:
+		return "Encoding(" + strconv.Itoa(int(e)) + ")"
+	}
+}
+
+// An EncodeError is returned by the Writer of an Encoding
+// when it is given a rune that the Encoding cannot represent.
+type EncodeError struct {
+	Rune     rune
+	Encoding Encoding
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("cannot encode %U in %s", e.Rune, e.Encoding)
+}
+
+// Reader returns a Reader that decodes runes in the encoding from r.
+// Invalid input is decoded as U+FFFD, the Unicode replacement character.
+//
+// UTF8 Readers are the same as UTF8Reader.
+// As with UTF8Reader, if r is a *bytes.Reader or *strings.Reader,
+// the returned Reader of a Latin1 or Windows1252 Encoding
+// has a Len method.
+func (e Encoding) Reader(r io.Reader) Reader {
+	switch e {
+	case UTF8:
+		return UTF8Reader(r)
+	case UTF16LE:
+		return &utf16Reader{readBuffer: readBuffer{r: r}, order: binary.LittleEndian}
+	case UTF16BE:
+		return &utf16Reader{readBuffer: readBuffer{r: r}, order: binary.BigEndian}
+	case Latin1, Windows1252:
+		br := &byteReader{r: r, enc: e}
+		if _, offs, size, ok := readerAtSize(r); ok {
+			return &sizedReader{Reader: br, n: size - offs}
+		}
+		return br
+	default:
+		panic("bad encoding")
+	}
+}
+
+// Writer returns a Writer that encodes runes in the encoding to w.
+// If a rune cannot be encoded, an *EncodeError is returned,
+// along with the number of runes before it, which were written.
+//
+// UTF8 and UTF-16 Writers encode invalid runes as U+FFFD,
+// and they never return an *EncodeError.
+func (e Encoding) Writer(w io.Writer) Writer {
+	switch e {
+	case UTF8:
+		return UTF8Writer(w)
+	case UTF16LE:
+		return &utf16Writer{w: w, order: binary.LittleEndian}
+	case UTF16BE:
+		return &utf16Writer{w: w, order: binary.BigEndian}
+	case Latin1, Windows1252:
+		return &byteWriter{w: w, enc: e}
+	default:
+		panic("bad encoding")
+	}
+}
+
+// DetectEncoding returns the most likely encoding
+// of text beginning with the given bytes.
+// If atEOF is false, the bytes are only a prefix of the text,
+// so they may end with an incomplete rune.
+//
+// A UTF-16 byte order mark determines the encoding.
+// Otherwise, the text is UTF-16 if it looks like
+// UTF-16 encoded ASCII, with many 0 bytes,
+// or UTF8 if it is valid UTF-8.
+// Otherwise it is Windows1252 if it uses any of the printable
+// characters of Windows1252 that are not in Latin1,
+// and Latin1 if it does not.
+func DetectEncoding(p []byte, atEOF bool) Encoding {
+	switch {
+	case len(p) >= 2 && p[0] == 0xFF && p[1] == 0xFE:
+		return UTF16LE
+	case len(p) >= 2 && p[0] == 0xFE && p[1] == 0xFF:
+		return UTF16BE
+	}
+
+	var zeros [2]int
+	for i, c := range p {
+		if c == 0 {
+			zeros[i%2]++
+		}
+	}
+	switch n := len(p) / 2; {
+	case n > 0 && zeros[1] > n/2 && zeros[0] < n/16+1:
+		return UTF16LE
+	case n > 0 && zeros[0] > n/2 && zeros[1] < n/16+1:
+		return UTF16BE
+	}
+
+	q := p
+	for i := len(q) - 1; !atEOF && i >= 0 && i >= len(q)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(q[i]) {
+			if !utf8.FullRune(q[i:]) {
+				q = q[:i]
+			}
+			break
+		}
+	}
+	if utf8.Valid(q) {
+		return UTF8
+	}
+
+	for _, c := range p {
+		if c >= 0x80 && c < 0xA0 && windows1252[c-0x80] != rune(c) {
+			return Windows1252
+		}
+	}
+	return Latin1
+}
+
+// Windows1252 maps the bytes 0x80–0x9F of code page 1252 to runes.
+var windows1252 = [0x20]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+type utf16Reader struct {
+	readBuffer
+	order binary.ByteOrder
+}
+
+func (r *utf16Reader) Read(p []rune) (int, error) {
+	var n int
+	for n < len(p) {
+		bs := r.buf[r.pos:]
+		if !r.eof && !r.fullRune(bs) {
+			if n > 0 {
+				break
+			}
+			if err := r.fill(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if len(bs) == 0 {
+			if n > 0 {
+				break
+			}
+			return 0, io.EOF
+		}
+		var w int
+		p[n], w = r.decodeRune(bs)
+		n++
+		r.pos += w
+	}
+	return n, nil
+}
+
+// FullRune returns whether the bytes begin with a full UTF-16 encoded rune.
+func (r *utf16Reader) fullRune(bs []byte) bool {
+	if len(bs) < 2 {
+		return false
+	}
+	u := r.order.Uint16(bs)
+	return u < 0xD800 || u >= 0xDC00 || len(bs) >= 4
+}
+
+// DecodeRune decodes the first rune from the bytes,
+// returning the rune and the number of bytes it used.
+func (r *utf16Reader) decodeRune(bs []byte) (rune, int) {
+	if len(bs) < 2 {
+		return utf8.RuneError, len(bs)
+	}
+	u := rune(r.order.Uint16(bs))
+	if u >= 0xD800 && u < 0xDC00 && len(bs) >= 4 {
+		if ru := utf16.DecodeRune(u, rune(r.order.Uint16(bs[2:]))); ru != utf8.RuneError {
+			return ru, 4
+		}
+	}
+	if utf16.IsSurrogate(u) {
+		return utf8.RuneError, 2
+	}
+	return u, 2
+}
+
+type utf16Writer struct {
+	w     io.Writer
+	order binary.ByteOrder
+	buf   []byte
+}
+
+func (w *utf16Writer) Write(p []rune) (int, error) {
+	w.buf = w.buf[:0]
+	for _, r := range p {
+		var u [2]byte
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			w.order.PutUint16(u[:], uint16(r1))
+			w.buf = append(w.buf, u[0], u[1])
+			r = r2
+		} else if !utf8.ValidRune(r) {
+			r = utf8.RuneError
+		}
+		w.order.PutUint16(u[:], uint16(r))
+		w.buf = append(w.buf, u[0], u[1])
+	}
+	n, err := w.w.Write(w.buf)
+	if n < len(w.buf) && err == nil {
+		err = io.ErrShortWrite
+	}
+	return runesBefore(p, n), err
+}
+
+// RunesBefore returns the number of runes of p
+// that are entirely encoded in the first n bytes of its UTF-16 encoding.
+func runesBefore(p []rune, n int) int {
+	var i int
+	for i < len(p) {
+		w := 2
+		if p[i] >= 0x10000 && p[i] <= utf8.MaxRune {
+			w = 4
+		}
+		if n < w {
+			break
+		}
+		n -= w
+		i++
+	}
+	return i
+}
+
+// A byteReader decodes an Encoding with one byte per rune.
+type byteReader struct {
+	r   io.Reader
+	enc Encoding
+	buf []byte
+}
+
+func (r *byteReader) Read(p []rune) (int, error) {
+	n := len(p)
+	if n > utf8ReaderBytes {
+		n = utf8ReaderBytes
+	}
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	n, err := r.r.Read(r.buf[:n])
+	for i, c := range r.buf[:n] {
+		p[i] = rune(c)
+		if r.enc == Windows1252 && c >= 0x80 && c < 0xA0 {
+			p[i] = windows1252[c-0x80]
+		}
+	}
+	if n > 0 && err == io.EOF {
+		// Return EOF on the next call.
+		err = nil
+	}
+	return n, err
+}
+
+// A byteWriter encodes an Encoding with one byte per rune.
+type byteWriter struct {
+	w   io.Writer
+	enc Encoding
+	buf []byte
+}
+
+func (w *byteWriter) Write(p []rune) (int, error) {
+	w.buf = w.buf[:0]
+	var err error
+	for _, r := range p {
+		c, ok := w.encodeRune(r)
+		if !ok {
+			err = &EncodeError{Rune: r, Encoding: w.enc}
+			break
+		}
+		w.buf = append(w.buf, c)
+	}
+	switch n, werr := w.w.Write(w.buf); {
+	case werr != nil:
+		return n, werr
+	case n < len(w.buf):
+		return n, io.ErrShortWrite
+	default:
+		return n, err
+	}
+}
+
+func (w *byteWriter) encodeRune(r rune) (byte, bool) {
+	if w.enc == Latin1 || r < 0x80 || r >= 0xA0 && r <= 0xFF {
+		return byte(r), r >= 0 && r <= 0xFF
+	}
+	for i, s := range windows1252 {
+		if s == r {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,143 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var encodingTests = []struct {
+	enc   Encoding
+	str   string
+	bytes string
+}{
+	{UTF8, "Hello, 世界!", "Hello, 世界!"},
+	{UTF16LE, "", ""},
+	{UTF16LE, "\uFEFFHi, 世界", "\xFF\xFEH\x00i\x00,\x00 \x00\x16\x4E\x4C\x75"},
+	{UTF16LE, "a😀b", "a\x00\x3D\xD8\x00\xDEb\x00"},
+	{UTF16BE, "\uFEFFHi, 世界", "\xFE\xFF\x00H\x00i\x00,\x00 \x4E\x16\x75\x4C"},
+	{UTF16BE, "a😀b", "\x00a\xD8\x3D\xDE\x00\x00b"},
+	{Latin1, "", ""},
+	{Latin1, "café ÿ\u0080\u0090", "caf\xE9 \xFF\x80\x90"},
+	{Windows1252, "“café” €5 — ok\u0081", "\x93caf\xE9\x94 \x805 \x97 ok\x81"},
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for _, test := range encodingTests {
+		rs, err := ReadAll(test.enc.Reader(strings.NewReader(test.bytes)))
+		if string(rs) != test.str || err != nil {
+			t.Errorf("ReadAll(%s.Reader(%q))=%q,%v, want %q,nil", test.enc, test.bytes, string(rs), err, test.str)
+		}
+		rs, err = ReadAll(test.enc.Reader(iotest.OneByteReader(strings.NewReader(test.bytes))))
+		if string(rs) != test.str || err != nil {
+			t.Errorf("ReadAll(%s.Reader(OneByteReader(%q)))=%q,%v, want %q,nil", test.enc, test.bytes, string(rs), err, test.str)
+		}
+
+		b := bytes.NewBuffer(nil)
+		w := test.enc.Writer(b)
+		if n, err := w.Write([]rune(test.str)); n != len([]rune(test.str)) || err != nil {
+			t.Errorf("%s.Writer(…).Write(%q)=%d,%v, want %d,nil", test.enc, test.str, n, err, len([]rune(test.str)))
+		}
+		if s := b.String(); s != test.bytes {
+			t.Errorf("%s.Writer(…).Write(%q) wrote %q, want %q", test.enc, test.str, s, test.bytes)
+		}
+	}
+}
+
+func TestEncodingInvalid(t *testing.T) {
+	tests := []struct {
+		enc        Encoding
+		bytes, str string
+	}{
+		{UTF16LE, "a\x00b", "a�"},
+		{UTF16LE, "\x3D\xD8a\x00", "�a"},
+		{UTF16LE, "\x3D\xD8", "�"},
+		{UTF16LE, "\x00\xDEa\x00", "�a"},
+		{UTF16BE, "\xD8\x3D\xD8\x3D\xDE\x00", "�😀"},
+	}
+	for _, test := range tests {
+		rs, err := ReadAll(test.enc.Reader(strings.NewReader(test.bytes)))
+		if string(rs) != test.str || err != nil {
+			t.Errorf("ReadAll(%s.Reader(%q))=%q,%v, want %q,nil", test.enc, test.bytes, string(rs), err, test.str)
+		}
+	}
+
+	b := bytes.NewBuffer(nil)
+	if _, err := UTF16LE.Writer(b).Write([]rune{0xD800, -1}); err != nil || b.String() != "\xFD\xFF\xFD\xFF" {
+		t.Errorf("UTF16LE.Writer(…).Write(invalid)=_,%v; wrote %q, want _,nil; %q", err, b.String(), "\xFD\xFF\xFD\xFF")
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	tests := []struct {
+		enc  Encoding
+		str  string
+		n    int
+		want string
+	}{
+		{Latin1, "café 世界", 5, "caf\xE9 "},
+		{Latin1, "€", 0, ""},
+		{Windows1252, "5€ 世界", 3, "5\x80 "},
+		{Windows1252, "\u0080", 0, ""},
+	}
+	for _, test := range tests {
+		rs := []rune(test.str)
+		b := bytes.NewBuffer(nil)
+		n, err := test.enc.Writer(b).Write(rs)
+		want := &EncodeError{Rune: rs[test.n], Encoding: test.enc}
+		if n != test.n || !reflect.DeepEqual(err, want) {
+			t.Errorf("%s.Writer(…).Write(%q)=%d,%v, want %d,%v", test.enc, test.str, n, err, test.n, want)
+		}
+		if s := b.String(); s != test.want {
+			t.Errorf("%s.Writer(…).Write(%q) wrote %q, want %q", test.enc, test.str, s, test.want)
+		}
+	}
+}
+
+func TestEncodingReaderLen(t *testing.T) {
+	r := Windows1252.Reader(strings.NewReader("\x93caf\xE9\x94"))
+	if n, ok := readLen(r); n != 6 || !ok {
+		t.Errorf("readLen(Windows1252.Reader(…))=%d,%v, want 6,true", n, ok)
+	}
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	if n, err := b.ReaderFrom(0).ReadFrom(r); n != 6 || err != nil {
+		t.Errorf("b.ReaderFrom(0).ReadFrom(…)=%d,%v, want 6,nil", n, err)
+	}
+	if s := b.String(); s != "“café”" {
+		t.Errorf("b.String()=%q, want %q", s, "“café”")
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		bytes string
+		atEOF bool
+		want  Encoding
+	}{
+		{"", true, UTF8},
+		{"Hello, World!", true, UTF8},
+		{"Hello, 世界!", true, UTF8},
+		{"\xEF\xBB\xBFHello", true, UTF8},
+		// Valid UTF-8, cut in the middle of a rune.
+		{"Hello, \xE4\xB8", false, UTF8},
+		{"Hello, \xE4\xB8", true, Latin1},
+		{"\xFF\xFE", true, UTF16LE},
+		{"\xFF\xFEH\x00i\x00", true, UTF16LE},
+		{"H\x00e\x00l\x00l\x00o\x00", true, UTF16LE},
+		{"\xFE\xFF\x00H\x00i", true, UTF16BE},
+		{"\x00H\x00e\x00l\x00l\x00o", true, UTF16BE},
+		{"caf\xE9", true, Latin1},
+		{"caf\xE9\x81", true, Latin1},
+		{"\x93caf\xE9\x94", true, Windows1252},
+	}
+	for _, test := range tests {
+		if enc := DetectEncoding([]byte(test.bytes), test.atEOF); enc != test.want {
+			t.Errorf("DetectEncoding(%q, %v)=%s, want %s", test.bytes, test.atEOF, enc, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"io"
+	"os"
+)
+
+type reverseReader struct {
+	*Buffer
+	pos     int64
+	version int64
+}
+
+// ReverseReader returns a Reader that reads the runes of the Buffer
+// before the given offset in reverse order,
+// beginning with the rune at offs-1 and ending with the rune at 0.
+// The returned Reader need not be closed.
+// If the Buffer is modified after the Reader is created,
+// Read returns ErrModified.
+func (b *Buffer) ReverseReader(offs int64) Reader {
+	return &reverseReader{Buffer: b, pos: offs, version: b.version}
+}
+
+// Len returns the number of runes in the unread portion of the reader.
+func (r *reverseReader) Len() int64 { return r.pos }
+
+func (r *reverseReader) Read(p []rune) (int, error) {
+	if r.version != r.Buffer.version {
+		return 0, ErrModified
+	}
+	if r.pos < 0 || r.pos > r.Size() {
+		return 0, os.ErrInvalid
+	}
+	if r.pos == 0 {
+		return 0, io.EOF
+	}
+	i, q0 := r.blocks.find(r.pos - 1)
+	blk, err := r.get(i)
+	if err != nil {
+		return 0, err
+	}
+	src := blk.data[:r.pos-q0]
+	n := len(p)
+	if n > len(src) {
+		n = len(src)
+	}
+	for j := 0; j < n; j++ {
+		p[j] = src[len(src)-j-1]
+	}
+	r.pos -= int64(n)
+	return n, nil
+}
+
+// An Iterator reads the runes of a Buffer in either direction.
+// It reads the most recently read block in place
+// while the block remains in the Buffer's cache,
+// so runes near each other are read without
+// looking up their block in the Buffer.
+//
+// If the Buffer is modified after the Iterator is created,
+// its methods return ErrModified.
+type Iterator struct {
+	b       *Buffer
+	pos     int64
+	version int64
+	// Blk is the most recently read block,
+	// and q0 is the offset of its first rune.
+	blk *block
+	q0  int64
+}
+
+// Iterator returns an Iterator positioned at the given offset.
+func (b *Buffer) Iterator(offs int64) *Iterator {
+	if offs < 0 || offs > b.Size() {
+		panic("rune index out of bounds")
+	}
+	return &Iterator{b: b, pos: offs, version: b.version}
+}
+
+// Pos returns the offset of the Iterator.
+// Next returns the rune at Pos, and Prev returns the rune before it.
+func (it *Iterator) Pos() int64 { return it.pos }
+
+// SetPos moves the Iterator to the given offset.
+// SetPos panics if the offset is out of range.
+func (it *Iterator) SetPos(offs int64) {
+	if offs < 0 || offs > it.b.Size() {
+		panic("rune index out of bounds")
+	}
+	it.pos = offs
+}
+
+// Next returns the rune at the Iterator's offset
+// and advances the offset by one.
+// At the end of the Buffer, Next returns io.EOF.
+func (it *Iterator) Next() (rune, error) {
+	if it.version != it.b.version {
+		return -1, ErrModified
+	}
+	if it.pos == it.b.Size() {
+		return -1, io.EOF
+	}
+	r, err := it.Rune(it.pos)
+	if err == nil {
+		it.pos++
+	}
+	return r, err
+}
+
+// Prev returns the rune before the Iterator's offset
+// and moves the offset back by one.
+// At the start of the Buffer, Prev returns io.EOF.
+func (it *Iterator) Prev() (rune, error) {
+	if it.version != it.b.version {
+		return -1, ErrModified
+	}
+	if it.pos == 0 {
+		return -1, io.EOF
+	}
+	r, err := it.Rune(it.pos - 1)
+	if err == nil {
+		it.pos--
+	}
+	return r, err
+}
+
+// Rune returns the rune at the given offset
+// without changing the Iterator's offset.
+// Rune panics if the offset is out of range.
+func (it *Iterator) Rune(offs int64) (rune, error) {
+	if it.version != it.b.version {
+		return -1, ErrModified
+	}
+	if offs < 0 || offs >= it.b.Size() {
+		panic("rune index out of bounds")
+	}
+	// The Buffer is unmodified, so the block still holds
+	// the same runes, but it may have been evicted from the cache.
+	blk := it.blk
+	if blk == nil || blk.data == nil || offs < it.q0 || offs >= it.q0+int64(blk.n) {
+		i, q0 := it.b.blocks.find(offs)
+		var err error
+		if blk, err = it.b.get(i); err != nil {
+			return -1, err
+		}
+		it.blk, it.q0 = blk, q0
+	}
+	return blk.data[offs-it.q0], nil
+}
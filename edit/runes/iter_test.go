@@ -0,0 +1,121 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"io"
+	"testing"
+)
+
+func reversed(s string) string {
+	rs := []rune(s)
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		rs[i], rs[j] = rs[j], rs[i]
+	}
+	return string(rs)
+}
+
+func TestReverseReader(t *testing.T) {
+	str := randomString(10 * testBlockSize)
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	b.SetCacheBlocks(2)
+	if err := b.Insert([]rune(str), 0); err != nil {
+		t.Fatalf("b.Insert(…, 0)=%v, want nil", err)
+	}
+	rs := []rune(str)
+	for _, offs := range []int64{0, 1, testBlockSize - 1, testBlockSize, 3*testBlockSize + 5, b.Size()} {
+		want := reversed(string(rs[:offs]))
+		r := b.ReverseReader(offs)
+		if n, ok := readLen(r); n != offs || !ok {
+			t.Errorf("readLen(b.ReverseReader(%d))=%d,%v, want %d,true", offs, n, ok, offs)
+		}
+		if got, err := ReadAll(r); string(got) != want || err != nil {
+			t.Errorf("ReadAll(b.ReverseReader(%d))=%q,%v, want %q,nil", offs, string(got), err, want)
+		}
+	}
+
+	// Short reads stop at the requested length.
+	r := b.ReverseReader(b.Size())
+	p := make([]rune, 3)
+	want := reversed(string(rs[len(rs)-3:]))
+	if n, err := r.Read(p); n != 3 || err != nil || string(p) != want {
+		t.Errorf("r.Read(3)=%d,%v (%q), want 3,nil (%q)", n, err, string(p), want)
+	}
+
+	if err := b.Delete(1, 0); err != nil {
+		t.Fatalf("b.Delete(1, 0)=%v, want nil", err)
+	}
+	if n, err := r.Read(p); n != 0 || err != ErrModified {
+		t.Errorf("r.Read(…) after delete=%d,%v, want 0,%v", n, err, ErrModified)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	str := randomString(10 * testBlockSize)
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	b.SetCacheBlocks(2)
+	if err := b.Insert([]rune(str), 0); err != nil {
+		t.Fatalf("b.Insert(…, 0)=%v, want nil", err)
+	}
+	rs := []rune(str)
+
+	it := b.Iterator(0)
+	if r, err := it.Prev(); r != -1 || err != io.EOF {
+		t.Errorf("it.Prev() at 0=%q,%v, want -1,%v", r, err, io.EOF)
+	}
+	for i, want := range rs {
+		if r, err := it.Next(); r != want || err != nil {
+			t.Fatalf("it.Next() at %d=%q,%v, want %q,nil", i, r, err, want)
+		}
+	}
+	if r, err := it.Next(); r != -1 || err != io.EOF {
+		t.Errorf("it.Next() at %d=%q,%v, want -1,%v", it.Pos(), r, err, io.EOF)
+	}
+	for i := len(rs) - 1; i >= 0; i-- {
+		if r, err := it.Prev(); r != rs[i] || err != nil {
+			t.Fatalf("it.Prev() at %d=%q,%v, want %q,nil", i+1, r, err, rs[i])
+		}
+	}
+	if p := it.Pos(); p != 0 {
+		t.Errorf("it.Pos()=%d, want 0", p)
+	}
+
+	it.SetPos(2*testBlockSize + 1)
+	for _, offs := range []int64{5, int64(len(rs) - 1), testBlockSize, testBlockSize - 1, 0} {
+		if r, err := it.Rune(offs); r != rs[offs] || err != nil {
+			t.Errorf("it.Rune(%d)=%q,%v, want %q,nil", offs, r, err, rs[offs])
+		}
+	}
+	if r, err := it.Next(); r != rs[2*testBlockSize+1] || err != nil {
+		t.Errorf("it.Next() at %d=%q,%v, want %q,nil", 2*testBlockSize+1, r, err, rs[2*testBlockSize+1])
+	}
+
+	// The Iterator's block is evicted from the cache.
+	it.SetPos(0)
+	if r, err := it.Next(); r != rs[0] || err != nil {
+		t.Errorf("it.Next() at 0=%q,%v, want %q,nil", r, err, rs[0])
+	}
+	for _, offs := range []int64{5 * testBlockSize, 7 * testBlockSize} {
+		if _, err := b.Rune(offs); err != nil {
+			t.Fatalf("b.Rune(%d)=_,%v, want _,nil", offs, err)
+		}
+	}
+	if r, err := it.Next(); r != rs[1] || err != nil {
+		t.Errorf("it.Next() at 1 after eviction=%q,%v, want %q,nil", r, err, rs[1])
+	}
+
+	if err := b.Insert([]rune("x"), 0); err != nil {
+		t.Fatalf("b.Insert(x, 0)=%v, want nil", err)
+	}
+	if _, err := it.Next(); err != ErrModified {
+		t.Errorf("it.Next() after insert=_,%v, want _,%v", err, ErrModified)
+	}
+	if _, err := it.Prev(); err != ErrModified {
+		t.Errorf("it.Prev() after insert=_,%v, want _,%v", err, ErrModified)
+	}
+	if _, err := it.Rune(0); err != ErrModified {
+		t.Errorf("it.Rune(0) after insert=_,%v, want _,%v", err, ErrModified)
+	}
+}
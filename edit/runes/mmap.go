@@ -0,0 +1,153 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"os"
+	"sync"
+)
+
+// MinMmapBytes is the minimum size of an MmapStore's mapping.
+const minMmapBytes = 1 << 16
+
+// An MmapStore is a ReaderWriterAt that stores its data in a file
+// which is mapped into memory.
+// Writing beyond the end of the MmapStore grows the file.
+//
+// An MmapStore is safe for concurrent use.
+type MmapStore struct {
+	mu sync.RWMutex
+	f  *os.File
+	// Data is the mapping of the file.
+	// The file may be larger than size;
+	// the bytes of data beyond size are zero.
+	data []byte
+	size int64
+}
+
+// NewMmapStore returns a new MmapStore of the file,
+// which must be open for reading and writing.
+// The MmapStore initially contains the contents of the file.
+// On systems that do not support mmap, an error is returned.
+func NewMmapStore(f *os.File) (*MmapStore, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m := &MmapStore{f: f, size: fi.Size()}
+	if m.size > 0 {
+		if m.data, err = mmap(f, int(m.size)); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Name returns the name of the MmapStore's file.
+func (m *MmapStore) Name() string { return m.f.Name() }
+
+// Size returns the number of bytes in the MmapStore.
+func (m *MmapStore) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.size
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (m *MmapStore) ReadAt(p []byte, offs int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return readAtBytes(m.data[:m.size], p, offs)
+}
+
+// WriteAt implements the io.WriterAt interface.
+func (m *MmapStore) WriteAt(p []byte, offs int64) (int, error) {
+	if offs < 0 {
+		return 0, errNegativeOffset
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := offs + int64(len(p)); end > m.size {
+		if err := m.resize(end); err != nil {
+			return 0, err
+		}
+	}
+	return copy(m.data[offs:], p), nil
+}
+
+// Truncate changes the size of the MmapStore.
+// If it grows, the new bytes are zero.
+func (m *MmapStore) Truncate(size int64) error {
+	if size < 0 {
+		return errNegativeOffset
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.resize(size)
+}
+
+// Resize changes the size of the MmapStore,
+// doubling the size of the file and its mapping if it must grow.
+func (m *MmapStore) resize(size int64) error {
+	if size <= int64(len(m.data)) {
+		for i := size; i < m.size; i++ {
+			m.data[i] = 0
+		}
+		m.size = size
+		return nil
+	}
+	n := 2 * int64(len(m.data))
+	if n < size {
+		n = size
+	}
+	if n < minMmapBytes {
+		n = minMmapBytes
+	}
+	if err := m.f.Truncate(n); err != nil {
+		return err
+	}
+	data, err := mmap(m.f, int(n))
+	if err != nil {
+		return err
+	}
+	if m.data != nil {
+		if err := munmap(m.data); err != nil {
+			munmap(data)
+			return err
+		}
+	}
+	m.data, m.size = data, size
+	return nil
+}
+
+func (m *MmapStore) unmap() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
+
+// Sync commits the contents of the MmapStore to stable storage.
+func (m *MmapStore) Sync() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.f.Sync()
+}
+
+// Close unmaps and closes the file,
+// truncating it to the size of the MmapStore.
+func (m *MmapStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.unmap(); err != nil {
+		m.f.Close()
+		return err
+	}
+	if err := m.f.Truncate(m.size); err != nil {
+		m.f.Close()
+		return err
+	}
+	return m.f.Close()
+}
@@ -0,0 +1,20 @@
+// Copyright © 2015, The T Authors.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package runes
+
+import (
+	"errors"
+	"os"
+)
+
+// HaveMmap is whether mmap is supported.
+const haveMmap = false
+
+var errNoMmap = errors.New("mmap is not supported")
+
+func mmap(*os.File, int) ([]byte, error) { return nil, errNoMmap }
+
+func munmap([]byte) error { return errNoMmap }
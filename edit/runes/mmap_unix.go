@@ -0,0 +1,20 @@
+// Copyright © 2015, The T Authors.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package runes
+
+import (
+	"os"
+	"syscall"
+)
+
+// HaveMmap is whether mmap is supported.
+const haveMmap = true
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error { return syscall.Munmap(data) }
@@ -0,0 +1,116 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import "unicode/utf8"
+
+// ByteOffset returns the offset in UTF-8 bytes
+// of the rune at the given rune offset.
+// Invalid runes are counted as U+FFFD, as UTF8Writer writes them.
+// ByteOffset panics if the offset is out of range.
+func (b *Buffer) ByteOffset(offs int64) (int64, error) {
+	return b.convert(offs, runeUnit, byteUnit)
+}
+
+// RuneOffset returns the rune offset
+// of the rune at the given offset in UTF-8 bytes.
+// If the byte offset is within the encoding of a rune,
+// the offset of that rune is returned.
+// RuneOffset panics if the offset is out of range.
+func (b *Buffer) RuneOffset(byteOffs int64) (int64, error) {
+	return b.convert(byteOffs, byteUnit, runeUnit)
+}
+
+// UTF16Offset returns the offset in UTF-16 code units
+// of the rune at the given rune offset.
+// UTF16Offset panics if the offset is out of range.
+func (b *Buffer) UTF16Offset(offs int64) (int64, error) {
+	return b.convert(offs, runeUnit, utf16Unit)
+}
+
+// RuneOffsetUTF16 returns the rune offset
+// of the rune at the given offset in UTF-16 code units.
+// If the offset is between the two code units of a surrogate pair,
+// the offset of the pair's rune is returned.
+// RuneOffsetUTF16 panics if the offset is out of range.
+func (b *Buffer) RuneOffsetUTF16(utf16Offs int64) (int64, error) {
+	return b.convert(utf16Offs, utf16Unit, runeUnit)
+}
+
+// Convert returns the offset, in the units selected by to,
+// of the rune at the offset p, in the units selected by from.
+// Only the block containing the rune is read.
+func (b *Buffer) convert(p int64, from, to func(offset) int64) (int64, error) {
+	total := b.blocks.root.totals()
+	if p < 0 || p > from(total) {
+		panic("offset out of range")
+	}
+	if p == from(total) {
+		return to(total), nil
+	}
+	i, q0 := b.blocks.locate(p, from)
+	blk, err := b.get(i)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range blk.data[:blk.n] {
+		q1 := q0.add(runeLens(r))
+		if from(q1) > p {
+			break
+		}
+		q0 = q1
+	}
+	return to(q0), nil
+}
+
+// An offset is a position in a Buffer
+// in runes, UTF-8 bytes, and UTF-16 code units.
+type offset struct{ runes, bytes, utf16 int64 }
+
+func (o offset) add(p offset) offset {
+	return offset{o.runes + p.runes, o.bytes + p.bytes, o.utf16 + p.utf16}
+}
+
+func runeUnit(o offset) int64  { return o.runes }
+func byteUnit(o offset) int64  { return o.bytes }
+func utf16Unit(o offset) int64 { return o.utf16 }
+
+// RuneLens returns the length of a rune
+// in runes, UTF-8 bytes, and UTF-16 code units.
+func runeLens(r rune) offset {
+	u8, u16 := widths(r)
+	return offset{1, int64(u8), int64(u16)}
+}
+
+// Measure returns the lengths of the runes
+// in UTF-8 bytes and UTF-16 code units.
+func measure(rs []rune) (u8, u16 int) {
+	for _, r := range rs {
+		w8, w16 := widths(r)
+		u8 += w8
+		u16 += w16
+	}
+	return u8, u16
+}
+
+// Widths returns the length of a rune
+// in UTF-8 bytes and UTF-16 code units.
+// Invalid runes are measured as U+FFFD.
+func widths(r rune) (u8, u16 int) {
+	switch {
+	case r < 0:
+		return 3, 1
+	case r < 0x80:
+		return 1, 1
+	case r < 0x800:
+		return 2, 1
+	case r < 0x10000:
+		// Surrogates are invalid, so they are measured as U+FFFD,
+		// which has the same lengths.
+		return 3, 1
+	case r <= utf8.MaxRune:
+		return 4, 2
+	default:
+		return 3, 1
+	}
+}
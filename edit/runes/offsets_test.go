@@ -0,0 +1,98 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// CheckOffsets checks the offset conversions of b,
+// which contains the given runes.
+func checkOffsets(t *testing.T, b *Buffer, rs []rune) {
+	var u8, u16 int64
+	for i, r := range rs {
+		if n, err := b.ByteOffset(int64(i)); n != u8 || err != nil {
+			t.Fatalf("b.ByteOffset(%d)=%d,%v, want %d,nil", i, n, err, u8)
+		}
+		if n, err := b.UTF16Offset(int64(i)); n != u16 || err != nil {
+			t.Fatalf("b.UTF16Offset(%d)=%d,%v, want %d,nil", i, n, err, u16)
+		}
+		w8 := int64(len(string(r)))
+		for j := u8; j < u8+w8; j++ {
+			if n, err := b.RuneOffset(j); n != int64(i) || err != nil {
+				t.Fatalf("b.RuneOffset(%d)=%d,%v, want %d,nil", j, n, err, i)
+			}
+		}
+		w16 := int64(len(utf16.Encode([]rune{r})))
+		for j := u16; j < u16+w16; j++ {
+			if n, err := b.RuneOffsetUTF16(j); n != int64(i) || err != nil {
+				t.Fatalf("b.RuneOffsetUTF16(%d)=%d,%v, want %d,nil", j, n, err, i)
+			}
+		}
+		u8 += w8
+		u16 += w16
+	}
+	end := int64(len(rs))
+	if n, err := b.ByteOffset(end); n != u8 || err != nil {
+		t.Fatalf("b.ByteOffset(%d)=%d,%v, want %d,nil", end, n, err, u8)
+	}
+	if n, err := b.RuneOffset(u8); n != end || err != nil {
+		t.Fatalf("b.RuneOffset(%d)=%d,%v, want %d,nil", u8, n, err, end)
+	}
+	if n, err := b.UTF16Offset(end); n != u16 || err != nil {
+		t.Fatalf("b.UTF16Offset(%d)=%d,%v, want %d,nil", end, n, err, u16)
+	}
+	if n, err := b.RuneOffsetUTF16(u16); n != end || err != nil {
+		t.Fatalf("b.RuneOffsetUTF16(%d)=%d,%v, want %d,nil", u16, n, err, end)
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	f := tempFile(t)
+	path := f.Name()
+	defer os.Remove(path)
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	b.SetCacheBlocks(2)
+
+	rand.Seed(0)
+	// Runes of each UTF-8 and UTF-16 length, and invalid runes.
+	letters := []rune{'a', 'é', '☺', '😀', -1, 0xD800, utf8.MaxRune + 1}
+	var want []rune
+	for i := 0; i < 200; i++ {
+		at := rand.Intn(len(want) + 1)
+		if i%3 == 0 && at < len(want) {
+			m := rand.Intn(len(want)-at) + 1
+			if err := b.Delete(int64(m), int64(at)); err != nil {
+				t.Fatalf("b.Delete(%d, %d)=%v, want nil", m, at, err)
+			}
+			want = append(want[:at], want[at+m:]...)
+			continue
+		}
+		rs := make([]rune, rand.Intn(2*testBlockSize)+1)
+		for j := range rs {
+			rs[j] = letters[rand.Intn(len(letters))]
+		}
+		if err := b.Insert(rs, int64(at)); err != nil {
+			t.Fatalf("b.Insert(%q, %d)=%v, want nil", string(rs), at, err)
+		}
+		want = append(want[:at], append(rs, want[at:]...)...)
+	}
+	checkOffsets(t, b, want)
+
+	if err := b.Compact(); err != nil {
+		t.Fatalf("b.Compact()=%v, want nil", err)
+	}
+	checkOffsets(t, b, want)
+
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	f.Close()
+	r := openBuffer(t, path)
+	defer r.Close()
+	checkOffsets(t, r, want)
+}
@@ -0,0 +1,266 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// The backing store of a Buffer begins with a header
+// that locates the Buffer's block index:
+//	magic [8]byte
+//	block size int64
+//	index offset int64
+//	index length int64, in bytes
+//
+// The index is written just beyond the end of the blocks:
+//	size int64, in runes
+//	block encoding int64
+//	number of blocks int64
+//	for each block in order:
+//		start int64
+//		space int64, in bytes
+//		encoded size int64, in bytes
+//		encoding int64
+//		checksum int64, CRC-32 (Castagnoli) of the encoded data
+//		n int64, in runes
+//		UTF-8 length int64, in bytes
+//		UTF-16 length int64, in code units
+//	number of free spaces int64
+//	for each free space: start int64, size int64
+//
+// All integers are little-endian.
+// The header and index are only written by Sync.
+// Until the next Sync, changed blocks are written to new space,
+// and the space of the synced blocks and index is not re-used,
+// so the synced index remains valid if the process exits.
+
+const (
+	magic       = "T runes\x03"
+	headerBytes = 32
+	intBytes    = 8
+	// MaxBlockSize is the largest block size, in runes,
+	// accepted by OpenBuffer.
+	maxBlockSize = 1 << 24
+)
+
+// ErrNotBuffer is returned by OpenBuffer if the backing store
+// does not contain a valid Buffer index.
+var ErrNotBuffer = errors.New("not a rune buffer")
+
+// OpenBuffer returns a Buffer backed by the given ReaderWriterAt,
+// with the contents recorded by the most recent Sync
+// of a Buffer with the same backing store.
+//
+// When the returned Buffer is closed, it is synced
+// and its backing store is closed, if it implements io.Closer,
+// but it is not removed.
+func OpenBuffer(f ReaderWriterAt) (*Buffer, error) {
+	hdr := make([]byte, headerBytes)
+	if err := readAt(f, hdr, 0); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrNotBuffer
+	} else if err != nil {
+		return nil, err
+	}
+	if string(hdr[:len(magic)]) != magic {
+		return nil, ErrNotBuffer
+	}
+	d := decoder{bs: hdr[len(magic):]}
+	blockSize, end, idxLen := d.int(), d.int(), d.int()
+	if blockSize <= 0 || blockSize > maxBlockSize || end < headerBytes || idxLen < 4*intBytes {
+		return nil, ErrNotBuffer
+	}
+	// The index length is not trusted to allocate the index;
+	// the index is read until idxLen bytes or the end of the store.
+	idx, err := ioutil.ReadAll(io.NewSectionReader(f, end, idxLen))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(idx)) < idxLen {
+		return nil, ErrNotBuffer
+	}
+
+	b := NewBufferReaderWriterAt(int(blockSize), f)
+	b.end = end
+	b.persist = true
+	d = decoder{bs: idx}
+	b.size = d.int()
+	b.enc = BlockEncoding(d.int())
+	if b.enc < RawBlocks || b.enc > CompressedBlocks {
+		return nil, ErrNotBuffer
+	}
+	var size int64
+	for i, nblks := int64(0), d.int(); i < nblks && d.err == nil; i++ {
+		blk := &block{
+			start:  d.int(),
+			space:  int(d.int()),
+			nbytes: int(d.int()),
+			enc:    BlockEncoding(d.int()),
+			crc:    uint32(d.int()),
+			n:      int(d.int()),
+			u8:     int(d.int()),
+			u16:    int(d.int()),
+		}
+		if !validSpan(span{blk.start, blk.space}, end) ||
+			blk.nbytes < 0 || blk.nbytes > blk.space ||
+			blk.enc < RawBlocks || blk.enc > CompressedBlocks ||
+			blk.n < 0 || blk.n > b.blockSize ||
+			blk.u8 < blk.n || blk.u8 > blk.n*utf8.UTFMax ||
+			blk.u16 < blk.n || blk.u16 > blk.n*2 {
+			return nil, ErrNotBuffer
+		}
+		b.blocks.insert(b.blocks.len(), blk)
+		size += int64(blk.n)
+	}
+	for i, nfree := int64(0), d.int(); i < nfree && d.err == nil; i++ {
+		sp := span{start: d.int(), size: int(d.int())}
+		if !validSpan(sp, end) {
+			return nil, ErrNotBuffer
+		}
+		b.free = append(b.free, sp)
+	}
+	if d.err != nil || size != b.size {
+		return nil, ErrNotBuffer
+	}
+	b.keepSynced(int(idxLen))
+	return b, nil
+}
+
+func validSpan(sp span, end int64) bool {
+	return sp.start >= headerBytes && sp.size > 0 && sp.start+int64(sp.size) <= end
+}
+
+// Sync writes the dirty cached blocks and the block index to the backing store,
+// so that the Buffer can be reopened with OpenBuffer.
+// If the backing store has a Sync method, such as *os.File, it is called.
+//
+// Once a Buffer is synced, Close syncs it again
+// and no longer removes its backing file.
+func (b *Buffer) Sync() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	f, err := b.file()
+	if err != nil {
+		return err
+	}
+	idx := b.index()
+	if _, err := f.WriteAt(idx, b.end); err != nil {
+		return err
+	}
+	hdr := make([]byte, 0, headerBytes)
+	hdr = append(hdr, magic...)
+	hdr = appendInt(hdr, int64(b.blockSize))
+	hdr = appendInt(hdr, b.end)
+	hdr = appendInt(hdr, int64(len(idx)))
+	if _, err := f.WriteAt(hdr, 0); err != nil {
+		return err
+	}
+	b.persist = true
+	b.keepSynced(len(idx))
+	if s, ok := f.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// KeepSynced records that the current blocks
+// and the index of the given length, at the end of the file,
+// are referenced by the header.
+// The space of the blocks and index of the previous Sync is released,
+// and the current blocks and index are kept until the next Sync.
+func (b *Buffer) keepSynced(idxLen int) {
+	b.snapLock.Lock()
+	defer b.snapLock.Unlock()
+	b.synced, b.syncGen = true, b.blocks.gen
+	b.blocks.gen++
+	b.release()
+	b.deferred = append(b.deferred, deferredBlock{
+		span: span{start: b.end, size: idxLen},
+		from: b.syncGen,
+		to:   b.blocks.gen,
+	})
+	b.end += int64(idxLen)
+}
+
+// IndexBytes returns the size in bytes of an index
+// with the given number of blocks and free spans.
+func indexBytes(nblocks, nfree int) int {
+	return (4 + 8*nblocks + 2*nfree) * intBytes
+}
+
+// Index returns the encoded block index.
+func (b *Buffer) index() []byte {
+	b.snapLock.Lock()
+	defer b.snapLock.Unlock()
+	// Deferred blocks are only referenced by snapshots,
+	// which do not survive reopening.
+	free := append([]span{}, b.free...)
+	for _, d := range b.deferred {
+		free = append(free, d.span)
+	}
+	idx := make([]byte, 0, indexBytes(b.blocks.len(), len(free)))
+	idx = appendInt(idx, b.size)
+	idx = appendInt(idx, int64(b.enc))
+	idx = appendInt(idx, int64(b.blocks.len()))
+	b.blocks.each(func(blk *block) {
+		idx = appendInt(idx, blk.start)
+		idx = appendInt(idx, int64(blk.space))
+		idx = appendInt(idx, int64(blk.nbytes))
+		idx = appendInt(idx, int64(blk.enc))
+		idx = appendInt(idx, int64(blk.crc))
+		idx = appendInt(idx, int64(blk.n))
+		idx = appendInt(idx, int64(blk.u8))
+		idx = appendInt(idx, int64(blk.u16))
+	})
+	idx = appendInt(idx, int64(len(free)))
+	for _, sp := range free {
+		idx = appendInt(idx, sp.start)
+		idx = appendInt(idx, int64(sp.size))
+	}
+	return idx
+}
+
+func appendInt(bs []byte, x int64) []byte {
+	var p [intBytes]byte
+	binary.LittleEndian.PutUint64(p[:], uint64(x))
+	return append(bs, p[:]...)
+}
+
+// A decoder decodes little-endian integers from a byte slice.
+// Once the slice is exhausted, err is set to ErrNotBuffer
+// and all further integers are 0.
+type decoder struct {
+	bs  []byte
+	err error
+}
+
+func (d *decoder) int() int64 {
+	if len(d.bs) < intBytes {
+		d.err = ErrNotBuffer
+		return 0
+	}
+	x := int64(binary.LittleEndian.Uint64(d.bs))
+	d.bs = d.bs[intBytes:]
+	return x
+}
+
+// ReadAt reads len(p) bytes at the given offset,
+// returning io.ErrUnexpectedEOF if fewer bytes were read.
+func readAt(f io.ReaderAt, p []byte, offs int64) error {
+	n, err := f.ReadAt(p, offs)
+	switch {
+	case n == len(p):
+		return nil
+	case err == nil || err == io.EOF:
+		return io.ErrUnexpectedEOF
+	default:
+		return err
+	}
+}
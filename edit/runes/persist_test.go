@@ -0,0 +1,211 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func tempFile(t *testing.T) *os.File {
+	f, err := ioutil.TempFile(os.TempDir(), "runes_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile(…)=_,%v, want _,nil", err)
+	}
+	return f
+}
+
+func openBuffer(t *testing.T, path string) *Buffer {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("os.OpenFile(%q, …)=_,%v, want _,nil", path, err)
+	}
+	b, err := OpenBuffer(f)
+	if err != nil {
+		f.Close()
+		t.Fatalf("OpenBuffer(%q)=_,%v, want _,nil", path, err)
+	}
+	return b
+}
+
+// BlockList returns the start and size of each block in the buffer.
+func blockList(b *Buffer) [][2]int64 {
+	var bl [][2]int64
+	b.blocks.each(func(blk *block) {
+		bl = append(bl, [2]int64{blk.start, int64(blk.n)})
+	})
+	return bl
+}
+
+func TestOpenBuffer(t *testing.T) {
+	f := tempFile(t)
+	path := f.Name()
+	defer os.Remove(path)
+
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	if err := b.Insert([]rune("01234567abcdefghSTUVWXYZ"), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	if err := b.Insert([]rune("!@#"), 12); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	if err := b.Delete(8, 0); err != nil {
+		t.Fatalf("b.Delete(8, 0)=%v, want nil", err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	end := b.end
+	// Changes after the Sync are not recorded.
+	if err := b.Insert([]rune("lost"), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+
+	r := openBuffer(t, path)
+	if s := r.String(); s != "abcd!@#efghSTUVWXYZ" {
+		t.Errorf("r.String()=%q, want %q", s, "abcd!@#efghSTUVWXYZ")
+	}
+	if r.blockSize != testBlockSize {
+		t.Errorf("r.blockSize=%d, want %d", r.blockSize, testBlockSize)
+	}
+	if r.end != end {
+		t.Errorf("r.end=%d, want %d", r.end, end)
+	}
+	if len(r.free) != 1 {
+		t.Errorf("len(r.free)=%d, want 1", len(r.free))
+	}
+	f.Close()
+
+	// Closing a reopened buffer syncs it and keeps the file.
+	if err := r.Insert([]rune("0123"), 0); err != nil {
+		t.Fatalf("r.Insert(…)=%v, want nil", err)
+	}
+	blocks := blockList(r)
+	if err := r.Close(); err != nil {
+		t.Fatalf("r.Close()=%v, want nil", err)
+	}
+	r = openBuffer(t, path)
+	defer r.Close()
+	if s := r.String(); s != "0123abcd!@#efghSTUVWXYZ" {
+		t.Errorf("r.String()=%q, want %q", s, "0123abcd!@#efghSTUVWXYZ")
+	}
+	if bl := blockList(r); !reflect.DeepEqual(bl, blocks) {
+		t.Errorf("blocks=%v, want %v", bl, blocks)
+	}
+}
+
+func TestOpenBufferNotBuffer(t *testing.T) {
+	f := tempFile(t)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := OpenBuffer(f); err != ErrNotBuffer {
+		t.Errorf("OpenBuffer(empty)=_,%v, want _,%v", err, ErrNotBuffer)
+	}
+	if _, err := f.WriteAt([]byte("Hello, World! This is not a buffer."), 0); err != nil {
+		t.Fatalf("f.WriteAt(…)=_,%v, want _,nil", err)
+	}
+	if _, err := OpenBuffer(f); err != ErrNotBuffer {
+		t.Errorf("OpenBuffer(garbage)=_,%v, want _,%v", err, ErrNotBuffer)
+	}
+
+	// A truncated index.
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	if err := b.Insert([]rune("Hello, World!"), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	// The index is written at the end of the blocks.
+	idxStart := b.end
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	if err := f.Truncate(idxStart + intBytes); err != nil {
+		t.Fatalf("f.Truncate(…)=%v, want nil", err)
+	}
+	if _, err := OpenBuffer(f); err != ErrNotBuffer {
+		t.Errorf("OpenBuffer(truncated)=_,%v, want _,%v", err, ErrNotBuffer)
+	}
+}
+
+func TestOpenBufferBadHeader(t *testing.T) {
+	tests := []struct {
+		name                   string
+		blockSize, end, idxLen int64
+	}{
+		{"huge index", testBlockSize, headerBytes, 1 << 62},
+		{"huge block size", 1 << 62, headerBytes, 4 * intBytes},
+		{"negative block size", -1, headerBytes, 4 * intBytes},
+		{"index past the end", testBlockSize, 1 << 40, 4 * intBytes},
+	}
+	for _, test := range tests {
+		hdr := []byte(magic)
+		hdr = appendInt(hdr, test.blockSize)
+		hdr = appendInt(hdr, test.end)
+		hdr = appendInt(hdr, test.idxLen)
+		// An empty index.
+		for i := 0; i < 4; i++ {
+			hdr = appendInt(hdr, 0)
+		}
+		f := &MemStore{}
+		if _, err := f.WriteAt(hdr, 0); err != nil {
+			t.Fatalf("f.WriteAt(…)=_,%v, want _,nil", err)
+		}
+		if _, err := OpenBuffer(f); err != ErrNotBuffer {
+			t.Errorf("OpenBuffer(%s)=_,%v, want _,%v", test.name, err, ErrNotBuffer)
+		}
+	}
+}
+
+func TestSyncCrashConsistent(t *testing.T) {
+	f := &MemStore{}
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	b.SetCacheBlocks(1)
+	str := randomString(10 * testBlockSize)
+	if err := b.Insert([]rune(str), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+
+	// Change every block and write them back,
+	// but exit without syncing.
+	for i := int64(0); i < b.Size(); i += testBlockSize / 2 {
+		if err := b.Delete(1, i); err != nil {
+			t.Fatalf("b.Delete(1, %d)=%v, want nil", i, err)
+		}
+		if err := b.Insert([]rune("xyz"), i); err != nil {
+			t.Fatalf("b.Insert(xyz, %d)=%v, want nil", i, err)
+		}
+	}
+	if err := b.flush(); err != nil {
+		t.Fatalf("b.flush()=%v, want nil", err)
+	}
+	crashed := &MemStore{data: append([]byte{}, f.data...)}
+
+	r, err := OpenBuffer(crashed)
+	if err != nil {
+		t.Fatalf("OpenBuffer(crashed)=_,%v, want _,nil", err)
+	}
+	if s := r.String(); s != str {
+		t.Errorf("r.String()=%q, want %q", s, str)
+	}
+
+	// The space of the synced blocks is re-used after the next Sync.
+	want := b.String()
+	if err := b.Sync(); err != nil {
+		t.Fatalf("b.Sync()=%v, want nil", err)
+	}
+	if len(b.deferred) != 1 {
+		t.Errorf("len(b.deferred)=%d, want 1, the index", len(b.deferred))
+	}
+	r, err = OpenBuffer(&MemStore{data: append([]byte{}, f.data...)})
+	if err != nil {
+		t.Fatalf("OpenBuffer(synced)=_,%v, want _,nil", err)
+	}
+	if s := r.String(); s != want {
+		t.Errorf("r.String()=%q, want %q", s, want)
+	}
+}
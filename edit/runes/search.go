@@ -0,0 +1,123 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import "io"
+
+// Index returns the offset of the first instance of pattern
+// in the Buffer beginning at or after from,
+// or -1 if there is none.
+// An empty pattern matches at from.
+// Index panics if from is out of range.
+func (b *Buffer) Index(pattern []rune, from int64) (int64, error) {
+	if from < 0 || from > b.Size() {
+		panic("rune index out of bounds")
+	}
+	k, err := b.search(b.Reader(from), pattern)
+	if k < 0 || err != nil {
+		return -1, err
+	}
+	return from + k, nil
+}
+
+// LastIndex returns the offset of the last instance of pattern
+// in the Buffer ending at or before from,
+// or -1 if there is none.
+// An empty pattern matches at from.
+// LastIndex panics if from is out of range.
+func (b *Buffer) LastIndex(pattern []rune, from int64) (int64, error) {
+	if from < 0 || from > b.Size() {
+		panic("rune index out of bounds")
+	}
+	rev := make([]rune, len(pattern))
+	for i, r := range pattern {
+		rev[len(rev)-i-1] = r
+	}
+	k, err := b.search(b.ReverseReader(from), rev)
+	if k < 0 || err != nil {
+		return -1, err
+	}
+	return from - k - int64(len(pattern)), nil
+}
+
+// IndexRune returns the offset of the first instance of r
+// in the Buffer at or after from,
+// or -1 if there is none.
+// IndexRune panics if from is out of range.
+func (b *Buffer) IndexRune(r rune, from int64) (int64, error) {
+	return b.Index([]rune{r}, from)
+}
+
+// Search returns the offset of the first instance of pattern
+// in the runes read from r, or -1 if there is none.
+//
+// It is a Boyer-Moore-Horspool search over a window of runes.
+// The window holds a block of runes read from r,
+// preceded by the runes of the previous window
+// that may begin a match.
+func (b *Buffer) search(r Reader, pattern []rune) (int64, error) {
+	m := len(pattern)
+	if m == 0 {
+		return 0, nil
+	}
+	var skip *[256]int
+	if m > 1 {
+		skip = skipTable(pattern)
+	}
+	last := pattern[m-1]
+	win := make([]rune, 0, m+b.blockSize)
+	// W0 is the offset of the first rune in win.
+	var w0 int64
+	for {
+		var s int
+		for s+m <= len(win) {
+			c := win[s+m-1]
+			if c == last && equalRunes(win[s:s+m-1], pattern[:m-1]) {
+				return w0 + int64(s), nil
+			}
+			if skip == nil {
+				s++
+			} else {
+				s += skip[uint8(c)]
+			}
+		}
+		// Fewer than m runes remain in the window.
+		n := copy(win, win[s:])
+		win = win[:n]
+		w0 += int64(s)
+
+		k, err := r.Read(win[n:cap(win)])
+		win = win[:n+k]
+		switch {
+		case err == io.EOF:
+			return -1, nil
+		case err != nil:
+			return -1, err
+		}
+	}
+}
+
+// SkipTable returns the Horspool shift table of a pattern
+// with at least two runes.
+// The table is indexed by the low byte of a rune,
+// so runes that share a low byte share the smallest of their shifts.
+func skipTable(pattern []rune) *[256]int {
+	m := len(pattern)
+	var skip [256]int
+	for i := range skip {
+		skip[i] = m
+	}
+	for i, r := range pattern[:m-1] {
+		skip[uint8(r)] = m - i - 1
+	}
+	return &skip
+}
+
+func equalRunes(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func naiveIndex(text, pattern []rune, from int) int {
+	for i := from; i+len(pattern) <= len(text); i++ {
+		if equalRunes(text[i:i+len(pattern)], pattern) {
+			return i
+		}
+	}
+	return -1
+}
+
+func naiveLastIndex(text, pattern []rune, from int) int {
+	for i := from - len(pattern); i >= 0; i-- {
+		if equalRunes(text[i:i+len(pattern)], pattern) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestIndex(t *testing.T) {
+	rand.Seed(0)
+	// Runes that share a low byte share a skip table entry.
+	const letters = "abšɡ"
+	rs := []rune(letters)
+	random := func(n int) []rune {
+		s := make([]rune, n)
+		for i := range s {
+			s[i] = rs[rand.Intn(len(rs))]
+		}
+		return s
+	}
+
+	text := random(20 * testBlockSize)
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	b.SetCacheBlocks(2)
+	if err := b.Insert(text, 0); err != nil {
+		t.Fatalf("b.Insert(…, 0)=%v, want nil", err)
+	}
+
+	patterns := [][]rune{
+		{},
+		[]rune("x"),
+		[]rune("a"),
+		[]rune("š"),
+		[]rune("ab"),
+		[]rune(strings.Repeat("a", testBlockSize+1)),
+		text[3*testBlockSize-2 : 3*testBlockSize+3],
+		text[len(text)-2*testBlockSize-1:],
+		text,
+	}
+	for i := 0; i < 20; i++ {
+		patterns = append(patterns, random(rand.Intn(6)+1))
+	}
+	for _, pat := range patterns {
+		for from := 0; from <= len(text); from++ {
+			want := int64(naiveIndex(text, pat, from))
+			if got, err := b.Index(pat, int64(from)); got != want || err != nil {
+				t.Fatalf("b.Index(%q, %d)=%d,%v, want %d,nil", string(pat), from, got, err, want)
+			}
+			want = int64(naiveLastIndex(text, pat, from))
+			if got, err := b.LastIndex(pat, int64(from)); got != want || err != nil {
+				t.Fatalf("b.LastIndex(%q, %d)=%d,%v, want %d,nil", string(pat), from, got, err, want)
+			}
+			if len(pat) != 1 {
+				continue
+			}
+			want = int64(naiveIndex(text, pat, from))
+			if got, err := b.IndexRune(pat[0], int64(from)); got != want || err != nil {
+				t.Fatalf("b.IndexRune(%q, %d)=%d,%v, want %d,nil", pat[0], from, got, err, want)
+			}
+		}
+	}
+}
+
+func TestIndexError(t *testing.T) {
+	f := &errReadWriterAt{}
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	b.SetCacheBlocks(1)
+	if err := b.Insert([]rune(strings.Repeat("a", 4*testBlockSize)), 0); err != nil {
+		t.Fatalf("b.Insert(…, 0)=%v, want nil", err)
+	}
+	// From here on, all IO causes an error.
+	f.error = errors.New("bad IO")
+	if n, err := b.Index([]rune("b"), 0); n != -1 || err != f.error {
+		t.Errorf("b.Index(b, 0)=%d,%v, want -1,%v", n, err, f.error)
+	}
+	if n, err := b.LastIndex([]rune("b"), b.Size()); n != -1 || err != f.error {
+		t.Errorf("b.LastIndex(b, %d)=%d,%v, want -1,%v", b.Size(), n, err, f.error)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"io"
+	"os"
+)
+
+// A Snapshot is a read-only view of the contents of a Buffer
+// at the time that the Snapshot was taken.
+//
+// Changes to the Buffer do not change its Snapshots,
+// and a Snapshot may be read by one goroutine
+// while its Buffer is changed by another.
+// A Snapshot must be closed before its Buffer is closed.
+type Snapshot struct {
+	buf    *Buffer
+	f      io.ReaderAt
+	blocks blockTree
+	// Cached is the index of the block whose data is cached, or -1.
+	cached int
+	cache  []rune
+}
+
+// A deferredBlock is the space of a freed block
+// that may be referenced by an open Snapshot.
+// It is referenced by snapshots of generations in [from, to).
+type deferredBlock struct {
+	span
+	from, to int64
+}
+
+// Snapshot returns a Snapshot of the current contents of the Buffer.
+//
+// Taking a Snapshot writes the dirty cached blocks to the backing store,
+// and copying a block is deferred until the Buffer next changes it.
+// The space of blocks referenced by a Snapshot
+// is not re-used until the Snapshot is closed.
+func (b *Buffer) Snapshot() (*Snapshot, error) {
+	if err := b.flush(); err != nil {
+		return nil, err
+	}
+	b.snapLock.Lock()
+	defer b.snapLock.Unlock()
+	if b.snaps == nil {
+		b.snaps = make(map[int64]bool)
+	}
+	s := &Snapshot{
+		buf:    b,
+		f:      b.f,
+		blocks: b.blocks,
+		cached: -1,
+		cache:  make([]rune, b.blockSize),
+	}
+	b.snaps[b.blocks.gen] = true
+	b.blocks.gen++
+	return s, nil
+}
+
+// Close closes the Snapshot,
+// allowing the Buffer to re-use the space of blocks
+// that are no longer referenced.
+func (s *Snapshot) Close() error {
+	b := s.buf
+	b.snapLock.Lock()
+	defer b.snapLock.Unlock()
+	delete(b.snaps, s.blocks.gen)
+	b.release()
+	s.cache = nil
+	return nil
+}
+
+// Release frees the deferred blocks
+// that are no longer referenced.
+//
+// This method must be called with the snapLock held.
+func (b *Buffer) release() {
+	var keep []deferredBlock
+	for _, d := range b.deferred {
+		if b.referenced(d) {
+			keep = append(keep, d)
+		} else {
+			b.free = append(b.free, d.span)
+		}
+	}
+	b.deferred = keep
+}
+
+// FreeSpan frees the space of a block,
+// allocated in the given generation.
+// If the space may be referenced by an open snapshot,
+// it is not re-used until the snapshot is closed,
+// and if it may be referenced by the synced index,
+// it is not re-used until the next Sync.
+func (b *Buffer) freeSpan(sp span, gen int64) {
+	b.snapLock.Lock()
+	defer b.snapLock.Unlock()
+	d := deferredBlock{span: sp, from: gen, to: b.blocks.gen}
+	if b.referenced(d) {
+		b.deferred = append(b.deferred, d)
+		return
+	}
+	b.free = append(b.free, sp)
+}
+
+// Referenced returns whether the deferred block
+// may be referenced by an open snapshot
+// or by the index written by the most recent Sync.
+func (b *Buffer) referenced(d deferredBlock) bool {
+	if b.synced && d.from <= b.syncGen && b.syncGen < d.to {
+		return true
+	}
+	for g := range b.snaps {
+		if d.from <= g && g < d.to {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the number of runes in the Snapshot.
+func (s *Snapshot) Size() int64 { return s.blocks.root.total() }
+
+// Rune returns the rune at the given offset.
+// If the rune is out of range it panics.
+func (s *Snapshot) Rune(offs int64) (rune, error) {
+	if offs < 0 || offs >= s.Size() {
+		panic("rune index out of bounds")
+	}
+	i, q0 := s.blocks.find(offs)
+	if _, err := s.get(i); err != nil {
+		return -1, err
+	}
+	return s.cache[offs-q0], nil
+}
+
+// Read reads runes from the Snapshot beginning at a given offset.
+// It is an error to read out of the range of the Snapshot.
+func (s *Snapshot) Read(n int, offs int64) ([]rune, error) {
+	return ReadAll(LimitReader(s.Reader(offs), int64(n)))
+}
+
+// Reader returns a Reader that reads from the Snapshot
+// beginning at the given offset.
+// The returned Reader need not be closed.
+func (s *Snapshot) Reader(offs int64) Reader {
+	return &snapshotReader{Snapshot: s, pos: offs}
+}
+
+type snapshotReader struct {
+	*Snapshot
+	pos int64
+}
+
+// Len returns the number of runes in the unread portion of the reader.
+func (r *snapshotReader) Len() int64 { return r.Size() - r.pos }
+
+func (r *snapshotReader) Read(p []rune) (int, error) {
+	if r.pos < 0 || r.pos > r.Size() {
+		return 0, os.ErrInvalid
+	}
+	if r.pos == r.Size() {
+		return 0, io.EOF
+	}
+	i, q0 := r.blocks.find(r.pos)
+	blk, err := r.get(i)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, r.cache[r.pos-q0:blk.n])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// Get loads the cache with the data from the block at the given index,
+// returning the block.
+func (s *Snapshot) get(i int) (*block, error) {
+	blk := s.blocks.at(i)
+	if s.cached == i {
+		return blk, nil
+	}
+	s.cached = -1
+	if err := readBlock(s.f, blk, s.cache); err == errBadBlock {
+		return nil, corruptBlock(&s.blocks, i)
+	} else if err != nil {
+		return nil, err
+	}
+	s.cached = i
+	return blk, nil
+}
@@ -0,0 +1,160 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// String returns a string containing the entire snapshot contents.
+func (s *Snapshot) String() string {
+	rs, err := ReadAll(s.Reader(0))
+	if err != nil {
+		panic(err)
+	}
+	return string(rs)
+}
+
+func TestSnapshot(t *testing.T) {
+	b := makeTestBytes(t)
+	defer b.Close()
+	const init = "01234567abcd!@#efghSTUVWXYZ"
+
+	s, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("b.Snapshot()=_,%v, want _,nil", err)
+	}
+	if err := b.Delete(10, 5); err != nil {
+		t.Fatalf("b.Delete(10, 5)=%v, want nil", err)
+	}
+	if err := b.Insert([]rune("Hello, 世界!"), 3); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	const want = "012Hello, 世界!34efghSTUVWXYZ"
+	if str := b.String(); str != want {
+		t.Errorf("b.String()=%q, want %q", str, want)
+	}
+	if str := s.String(); str != init {
+		t.Errorf("s.String()=%q, want %q", str, init)
+	}
+	if sz := s.Size(); sz != int64(len(init)) {
+		t.Errorf("s.Size()=%d, want %d", sz, len(init))
+	}
+	if r, err := s.Rune(26); r != 'Z' || err != nil {
+		t.Errorf("s.Rune(26)=%q,%v, want 'Z',nil", r, err)
+	}
+	if rs, err := s.Read(4, 12); string(rs) != "!@#e" || err != nil {
+		t.Errorf("s.Read(4, 12)=%q,%v, want \"!@#e\",nil", string(rs), err)
+	}
+
+	// Blocks replaced while the snapshot is open are not re-used.
+	if len(b.deferred) == 0 {
+		t.Errorf("len(b.deferred)=0, want >0")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("s.Close()=%v, want nil", err)
+	}
+	if len(b.deferred) != 0 {
+		t.Errorf("after close len(b.deferred)=%d, want 0", len(b.deferred))
+	}
+	if str := b.String(); str != want {
+		t.Errorf("after close b.String()=%q, want %q", str, want)
+	}
+}
+
+// TestSnapshotRandom tests random edits with many open snapshots.
+func TestSnapshotRandom(t *testing.T) {
+	rand.Seed(0)
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	if err := b.SetCacheBlocks(3); err != nil {
+		t.Fatalf("b.SetCacheBlocks(3)=%v, want nil", err)
+	}
+	type snap struct {
+		s    *Snapshot
+		want string
+	}
+	var snaps []snap
+	var want []rune
+	for i := 0; i < 500; i++ {
+		switch at := rand.Intn(len(want) + 1); {
+		case i%25 == 0:
+			s, err := b.Snapshot()
+			if err != nil {
+				t.Fatalf("b.Snapshot()=_,%v, want _,nil", err)
+			}
+			snaps = append(snaps, snap{s: s, want: string(want)})
+		case i%40 == 0 && len(snaps) > 0:
+			j := rand.Intn(len(snaps))
+			if err := snaps[j].s.Close(); err != nil {
+				t.Fatalf("s.Close()=%v, want nil", err)
+			}
+			snaps = append(snaps[:j], snaps[j+1:]...)
+		case rand.Intn(3) == 0 && at < len(want):
+			m := rand.Intn(len(want)-at) + 1
+			if err := b.Delete(int64(m), int64(at)); err != nil {
+				t.Fatalf("b.Delete(%d, %d)=%v, want nil", m, at, err)
+			}
+			want = append(want[:at], want[at+m:]...)
+		default:
+			rs := []rune(randomString(rand.Intn(2 * testBlockSize)))
+			if err := b.Insert(rs, int64(at)); err != nil {
+				t.Fatalf("b.Insert(%q, %d)=%v, want nil", string(rs), at, err)
+			}
+			want = append(want[:at], append(rs, want[at:]...)...)
+		}
+		for _, s := range snaps {
+			if str := s.s.String(); str != s.want {
+				t.Fatalf("s.String()=%q, want %q", str, s.want)
+			}
+		}
+	}
+	if str := b.String(); str != string(want) {
+		t.Errorf("b.String()=%q, want %q", str, string(want))
+	}
+	for _, s := range snaps {
+		s.s.Close()
+	}
+	if len(b.deferred) != 0 {
+		t.Errorf("len(b.deferred)=%d, want 0", len(b.deferred))
+	}
+}
+
+// TestSnapshotConcurrent tests reading a snapshot
+// while its buffer is changed by another goroutine.
+func TestSnapshotConcurrent(t *testing.T) {
+	b := NewBuffer(testBlockSize)
+	defer b.Close()
+	const str = "Hello, 世界! Hello, World!"
+	if err := b.Insert([]rune(str), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	s, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("b.Snapshot()=_,%v, want _,nil", err)
+	}
+	done := make(chan string)
+	go func() {
+		var got string
+		for i := 0; i < 100; i++ {
+			got = s.String()
+			if got != str {
+				break
+			}
+		}
+		s.Close()
+		done <- got
+	}()
+	for i := 0; i < 100; i++ {
+		if err := b.Insert([]rune("abc"), int64(i%10)); err != nil {
+			t.Fatalf("b.Insert(…)=%v, want nil", err)
+		}
+		if err := b.Delete(2, int64(i%7)); err != nil {
+			t.Fatalf("b.Delete(…)=%v, want nil", err)
+		}
+	}
+	if got := <-done; got != str {
+		t.Errorf("s.String()=%q, want %q", got, str)
+	}
+}
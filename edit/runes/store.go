@@ -0,0 +1,142 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// MaxMemStoreBytes is the largest expected size, in bytes,
+// of a Buffer created by NewBufferSize that is backed by a MemStore.
+// Larger Buffers are backed by an MmapStore,
+// or by a file where mmap is not supported.
+const MaxMemStoreBytes = 1 << 20
+
+var errNegativeOffset = errors.New("negative offset")
+
+// NewBufferSize is like NewBuffer, but it chooses the backing store
+// by the expected size of the buffer in runes.
+// Small buffers are backed by a MemStore,
+// and large buffers are backed by an MmapStore of a temporary file,
+// or by the temporary file itself where mmap is not supported.
+// The temporary file is removed when the buffer is closed.
+// As with NewBuffer, the backing store is created lazily.
+func NewBufferSize(blockSize int, size int64) *Buffer {
+	b := NewBuffer(blockSize)
+	switch {
+	case size*runeBytes <= MaxMemStoreBytes:
+		b.newStore = func() (ReaderWriterAt, error) { return new(MemStore), nil }
+	case haveMmap:
+		b.newStore = newTempMmapStore
+	default:
+		b.newStore = newTempFile
+	}
+	return b
+}
+
+// NewTempFile returns a new temporary file.
+func newTempFile() (ReaderWriterAt, error) {
+	return ioutil.TempFile(os.TempDir(), "edit")
+}
+
+// NewTempMmapStore returns an MmapStore of a new temporary file.
+func newTempMmapStore() (ReaderWriterAt, error) {
+	f, err := ioutil.TempFile(os.TempDir(), "edit")
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMmapStore(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return m, nil
+}
+
+// A MemStore is a ReaderWriterAt that stores its data in memory,
+// growing as needed to hold everything written to it.
+// The zero value is an empty MemStore.
+//
+// A MemStore is safe for concurrent use.
+type MemStore struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// Size returns the number of bytes in the MemStore.
+func (m *MemStore) Size() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.data))
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (m *MemStore) ReadAt(p []byte, offs int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return readAtBytes(m.data, p, offs)
+}
+
+// WriteAt implements the io.WriterAt interface.
+// Writing beyond the end of the MemStore grows it,
+// filling any gap with zeros.
+func (m *MemStore) WriteAt(p []byte, offs int64) (int, error) {
+	if offs < 0 {
+		return 0, errNegativeOffset
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := offs + int64(len(p)); end > int64(len(m.data)) {
+		m.resize(end)
+	}
+	return copy(m.data[offs:], p), nil
+}
+
+// Truncate changes the size of the MemStore.
+// If it grows, the new bytes are zero.
+func (m *MemStore) Truncate(size int64) error {
+	if size < 0 {
+		return errNegativeOffset
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resize(size)
+	return nil
+}
+
+// Resize changes the size of the data,
+// doubling its capacity if it must grow.
+func (m *MemStore) resize(size int64) {
+	n := int(size)
+	if n <= cap(m.data) {
+		old := len(m.data)
+		m.data = m.data[:n]
+		for i := old; i < n; i++ {
+			m.data[i] = 0
+		}
+		return
+	}
+	data := make([]byte, n, 2*n)
+	copy(data, m.data)
+	m.data = data
+}
+
+// ReadAtBytes implements io.ReaderAt for a byte slice.
+func readAtBytes(data, p []byte, offs int64) (int, error) {
+	if offs < 0 {
+		return 0, errNegativeOffset
+	}
+	if offs >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[offs:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
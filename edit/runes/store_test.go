@@ -0,0 +1,171 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+type store interface {
+	ReaderWriterAt
+	Size() int64
+	Truncate(int64) error
+}
+
+func testStore(t *testing.T, name string, s store) {
+	read := func(n int, offs int64, want string, wantErr error) {
+		p := make([]byte, n)
+		m, err := s.ReadAt(p, offs)
+		if string(p[:m]) != want || err != wantErr {
+			t.Errorf("%s.ReadAt(%d, %d)=%q,%v, want %q,%v", name, n, offs, p[:m], err, want, wantErr)
+		}
+	}
+	write := func(str string, offs int64) {
+		if n, err := s.WriteAt([]byte(str), offs); n != len(str) || err != nil {
+			t.Fatalf("%s.WriteAt(%q, %d)=%d,%v, want %d,nil", name, str, offs, n, err, len(str))
+		}
+	}
+	truncate := func(n int64) {
+		if err := s.Truncate(n); err != nil {
+			t.Fatalf("%s.Truncate(%d)=%v, want nil", name, n, err)
+		}
+		if sz := s.Size(); sz != n {
+			t.Errorf("%s.Size()=%d, want %d", name, sz, n)
+		}
+	}
+
+	read(1, 0, "", io.EOF)
+	write("Hello", 0)
+	write("World", 7)
+	if sz := s.Size(); sz != 12 {
+		t.Errorf("%s.Size()=%d, want 12", name, sz)
+	}
+	read(12, 0, "Hello\x00\x00World", nil)
+	read(6, 9, "rld", io.EOF)
+	read(1, 12, "", io.EOF)
+	if _, err := s.ReadAt(make([]byte, 1), -1); err == nil {
+		t.Errorf("%s.ReadAt(1, -1)=_,nil, want _,error", name)
+	}
+	if _, err := s.WriteAt([]byte("x"), -1); err == nil {
+		t.Errorf("%s.WriteAt(1, -1)=_,nil, want _,error", name)
+	}
+
+	// Grow well beyond the initial size.
+	big := randomString(1 << 17)
+	write(big, 3)
+	read(5, int64(3+len(big)-5), big[len(big)-5:], nil)
+	read(3, 0, "Hel", nil)
+	write("Hello", 0)
+
+	// Truncated bytes read as zero when the store grows again.
+	truncate(4)
+	read(5, 0, "Hell", io.EOF)
+	truncate(6)
+	read(6, 0, "Hell\x00\x00", nil)
+	write("!", 8)
+	read(9, 0, "Hell\x00\x00\x00\x00!", nil)
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, "m", new(MemStore))
+}
+
+func TestMmapStore(t *testing.T) {
+	f := tempFile(t)
+	path := f.Name()
+	defer os.Remove(path)
+	if _, err := f.WriteAt([]byte("abc"), 0); err != nil {
+		t.Fatalf("f.WriteAt(…)=_,%v, want _,nil", err)
+	}
+	m, err := NewMmapStore(f)
+	if err != nil {
+		t.Fatalf("NewMmapStore(…)=_,%v, want _,nil", err)
+	}
+	p := make([]byte, 3)
+	if n, err := m.ReadAt(p, 0); n != 3 || err != nil || string(p) != "abc" {
+		t.Errorf("m.ReadAt(3, 0)=%d,%v (%q), want 3,nil (%q)", n, err, p, "abc")
+	}
+	if err := m.Truncate(0); err != nil {
+		t.Fatalf("m.Truncate(0)=%v, want nil", err)
+	}
+	testStore(t, "m", m)
+	if err := m.Close(); err != nil {
+		t.Fatalf("m.Close()=%v, want nil", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() != 9 {
+		t.Errorf("os.Stat(%q).Size()=%d,%v, want 9,nil", path, fi.Size(), err)
+	}
+}
+
+func TestNewBufferSize(t *testing.T) {
+	small := NewBufferSize(testBlockSize, 100)
+	defer small.Close()
+	large := NewBufferSize(testBlockSize, MaxMemStoreBytes)
+
+	const str = "01234567abcdefghSTUVWXYZ"
+	for _, b := range []*Buffer{small, large} {
+		if err := b.Insert([]rune(str), 0); err != nil {
+			t.Fatalf("b.Insert(…)=%v, want nil", err)
+		}
+		if err := b.Delete(8, 4); err != nil {
+			t.Fatalf("b.Delete(8, 4)=%v, want nil", err)
+		}
+		if s := b.String(); s != "0123efghSTUVWXYZ" {
+			t.Errorf("b.String()=%q, want %q", s, "0123efghSTUVWXYZ")
+		}
+	}
+	if _, ok := small.f.(*MemStore); !ok {
+		t.Errorf("small.f.(type)=%T, want *MemStore", small.f)
+	}
+	var path string
+	switch f := large.f.(type) {
+	case *MmapStore:
+		path = f.Name()
+	case *os.File:
+		if haveMmap {
+			t.Errorf("large.f.(type)=%T, want *MmapStore", large.f)
+		}
+		path = f.Name()
+	default:
+		t.Fatalf("large.f.(type)=%T, want *MmapStore or *os.File", large.f)
+	}
+	if err := large.Close(); err != nil {
+		t.Fatalf("large.Close()=%v, want nil", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q)=_,%v, want _,not exist", path, err)
+	}
+}
+
+func TestCloseKeepsGivenFile(t *testing.T) {
+	f := tempFile(t)
+	path := f.Name()
+	defer os.Remove(path)
+	b := NewBufferReaderWriterAt(testBlockSize, f)
+	if err := b.Insert([]rune("Hello, 世界!"), 0); err != nil {
+		t.Fatalf("b.Insert(…)=%v, want nil", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("b.Close()=%v, want nil", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("os.Stat(%q)=_,%v, want _,nil", path, err)
+	}
+
+	m, err := NewMmapStore(tempFile(t))
+	if err != nil {
+		t.Skipf("NewMmapStore(…)=_,%v", err)
+	}
+	path = m.Name()
+	defer os.Remove(path)
+	b = NewBufferReaderWriterAt(testBlockSize, m)
+	if err := b.Close(); err != nil {
+		t.Fatalf("b.Close()=%v, want nil", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("os.Stat(%q)=_,%v, want _,nil", path, err)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Utf8ReaderBytes is the number of bytes buffered by a UTF8Reader.
+const utf8ReaderBytes = 32 * 1024
+
+// A UTF8Error is returned by a Reader from StrictUTF8Reader
+// when it encounters an invalid UTF-8 sequence.
+type UTF8Error struct {
+	// Offset is the byte offset of the invalid sequence
+	// from the beginning of the reader.
+	Offset int64
+}
+
+func (e *UTF8Error) Error() string {
+	return "invalid UTF-8 at byte " + strconv.FormatInt(e.Offset, 10)
+}
+
+// UTF8Reader returns a Reader that decodes UTF-8 from r.
+// Invalid UTF-8 bytes are each decoded as U+FFFD,
+// the Unicode replacement character.
+//
+// If r is a *bytes.Reader or *strings.Reader,
+// the returned Reader has a Len method
+// returning the number of runes that remain to be read,
+// so it can be copied efficiently into a Buffer.
+// The runes are counted when the Reader is created.
+func UTF8Reader(r io.Reader) Reader { return newUTF8Reader(r, false) }
+
+// StrictUTF8Reader is like UTF8Reader,
+// but if it encounters an invalid UTF-8 sequence,
+// it returns a *UTF8Error and no further runes are read.
+// Its Len method, if any, counts the runes
+// beyond the first invalid sequence too.
+func StrictUTF8Reader(r io.Reader) Reader { return newUTF8Reader(r, true) }
+
+func newUTF8Reader(r io.Reader, strict bool) Reader {
+	u := &utf8Reader{readBuffer: readBuffer{r: r}, strict: strict}
+	if ra, offs, size, ok := readerAtSize(r); ok {
+		if n, err := countRunes(ra, offs, size); err == nil {
+			return &sizedReader{Reader: u, n: n}
+		}
+	}
+	return u
+}
+
+// A readBuffer buffers bytes read from an io.Reader for decoding.
+type readBuffer struct {
+	r io.Reader
+	// Buf[pos:] are the bytes read from r that are not yet decoded.
+	buf []byte
+	pos int
+	// Eof is whether r has returned io.EOF.
+	eof bool
+	// Err is an error returned by r, to be returned
+	// once the bytes before it are decoded.
+	err error
+}
+
+type utf8Reader struct {
+	readBuffer
+	strict bool
+	// Offs is the byte offset of buf[pos] from the beginning of r.
+	offs int64
+	// Bad is a *UTF8Error for an invalid sequence in strict mode.
+	bad error
+}
+
+func (r *utf8Reader) Read(p []rune) (int, error) {
+	if r.bad != nil {
+		return 0, r.bad
+	}
+	var n int
+	for n < len(p) {
+		if !r.eof && !utf8.FullRune(r.buf[r.pos:]) {
+			if n > 0 {
+				break
+			}
+			if err := r.fill(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if r.pos == len(r.buf) {
+			if n > 0 {
+				break
+			}
+			return 0, io.EOF
+		}
+		m, err := r.decode(p[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Fill moves the undecoded bytes to the front of the buffer
+// and reads more bytes from r after them.
+func (r *readBuffer) fill() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.buf == nil {
+		r.buf = make([]byte, 0, utf8ReaderBytes)
+	}
+	k := copy(r.buf[:cap(r.buf)], r.buf[r.pos:])
+	m, err := r.r.Read(r.buf[k:cap(r.buf)])
+	r.buf, r.pos = r.buf[:k+m], 0
+	switch {
+	case err == io.EOF:
+		r.eof = true
+	case err != nil && m == 0:
+		r.err = err
+		return err
+	case err != nil:
+		r.err = err
+	}
+	return nil
+}
+
+// Decode decodes runes from the buffer into p,
+// stopping at an incomplete rune if r has more to read.
+func (r *utf8Reader) decode(p []rune) (int, error) {
+	bs := r.buf[r.pos:]
+	var n, i int
+	for n < len(p) && i < len(bs) {
+		if c := bs[i]; c < utf8.RuneSelf {
+			p[n] = rune(c)
+			n++
+			i++
+			continue
+		}
+		if !r.eof && !utf8.FullRune(bs[i:]) {
+			break
+		}
+		ru, w := utf8.DecodeRune(bs[i:])
+		if ru == utf8.RuneError && w == 1 && r.strict {
+			r.bad = &UTF8Error{Offset: r.offs + int64(i)}
+			r.pos += i
+			r.offs += int64(i)
+			return n, r.bad
+		}
+		p[n] = ru
+		n++
+		i += w
+	}
+	r.pos += i
+	r.offs += int64(i)
+	return n, nil
+}
+
+// A sizedReader is a Reader with a known number of runes.
+type sizedReader struct {
+	Reader
+	// N is the number of runes remaining to be read.
+	n int64
+}
+
+// Len returns the number of runes remaining to be read.
+func (r *sizedReader) Len() int64 { return r.n }
+
+func (r *sizedReader) Read(p []rune) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n -= int64(n)
+	return n, err
+}
+
+// ReaderAtSize returns an io.ReaderAt of r's data,
+// the offset of r's next byte in the io.ReaderAt,
+// and the size of the io.ReaderAt,
+// or false if r is not one of the supported types.
+// Files are not supported, because their size can change while they are read.
+func readerAtSize(r io.Reader) (io.ReaderAt, int64, int64, bool) {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		return r, r.Size() - int64(r.Len()), r.Size(), true
+	case *strings.Reader:
+		return r, r.Size() - int64(r.Len()), r.Size(), true
+	}
+	return nil, 0, 0, false
+}
+
+// CountRunes returns the number of runes
+// decoded from the bytes of ra between offs and size.
+func countRunes(ra io.ReaderAt, offs, size int64) (int64, error) {
+	var n int64
+	buf := make([]byte, 0, utf8ReaderBytes)
+	for {
+		m := cap(buf) - len(buf)
+		if rem := size - offs; rem < int64(m) {
+			m = int(rem)
+		}
+		if m < 0 {
+			m = 0
+		}
+		k, err := ra.ReadAt(buf[len(buf):len(buf)+m], offs)
+		if k < m && err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && !(err == io.EOF && k == m) {
+			return 0, err
+		}
+		buf = buf[:len(buf)+k]
+		offs += int64(k)
+		eof := offs >= size
+
+		var i int
+		for i < len(buf) {
+			if buf[i] < utf8.RuneSelf {
+				i++
+			} else if !eof && !utf8.FullRune(buf[i:]) {
+				break
+			} else {
+				_, w := utf8.DecodeRune(buf[i:])
+				i += w
+			}
+			n++
+		}
+		if eof {
+			return n, nil
+		}
+		buf = buf[:copy(buf, buf[i:])]
+	}
+}
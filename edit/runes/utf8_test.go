@@ -0,0 +1,145 @@
+// Copyright © 2015, The T Authors.
+
+package runes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestUTF8Reader(t *testing.T) {
+	str := string(helloWorldTestRunes)
+	helloWorldReadTests.run(t, UTF8Reader(strings.NewReader(str)))
+	helloWorldReadTests.run(t, UTF8Reader(bytes.NewReader([]byte(str))))
+	helloWorldReadTests.run(t, UTF8Reader(bytes.NewBufferString(str)))
+	helloWorldReadTests.run(t, UTF8Reader(iotest.HalfReader(strings.NewReader(str))))
+
+	// Runes split across reads are decoded as a single rune.
+	r := UTF8Reader(iotest.OneByteReader(strings.NewReader(str)))
+	if rs, err := ReadAll(r); string(rs) != str || err != nil {
+		t.Errorf("ReadAll(UTF8Reader(OneByteReader(%q)))=%q,%v, want %q,nil", str, string(rs), err, str)
+	}
+
+	r = UTF8Reader(iotest.DataErrReader(strings.NewReader("αβξ")))
+	if rs, err := ReadAll(r); string(rs) != "αβξ" || err != nil {
+		t.Errorf("ReadAll(UTF8Reader(DataErrReader(…)))=%q,%v, want %q,nil", string(rs), err, "αβξ")
+	}
+
+	// Errors are returned after the preceding runes.
+	r = UTF8Reader(iotest.TimeoutReader(strings.NewReader("αβξ")))
+	if rs, err := ReadAll(r); string(rs) != "αβξ" || err != iotest.ErrTimeout {
+		t.Errorf("ReadAll(UTF8Reader(TimeoutReader(…)))=%q,%v, want %q,%v", string(rs), err, "αβξ", iotest.ErrTimeout)
+	}
+}
+
+func TestUTF8ReaderInvalid(t *testing.T) {
+	tests := []struct {
+		str, want string
+		// Offs is the offset of the first invalid sequence, or -1.
+		offs int64
+	}{
+		{str: "", want: "", offs: -1},
+		{str: "Hello, 世界", want: "Hello, 世界", offs: -1},
+		{str: "�", want: "�", offs: -1},
+		{str: "\xFF", want: "�", offs: 0},
+		{str: "a\xFFb", want: "a�b", offs: 1},
+		{str: "世\xE4\xB8", want: "世��", offs: 3},
+		{str: "世\xE4\xB8界", want: "世��界", offs: 3},
+		{str: "\xC0\x80", want: "��", offs: 0},
+		{
+			str:  strings.Repeat("α", utf8ReaderBytes) + "\xFF",
+			want: strings.Repeat("α", utf8ReaderBytes) + "�",
+			offs: 2 * utf8ReaderBytes,
+		},
+	}
+	for _, test := range tests {
+		for _, src := range []io.Reader{
+			strings.NewReader(test.str),
+			iotest.OneByteReader(strings.NewReader(test.str)),
+		} {
+			rs, err := ReadAll(UTF8Reader(src))
+			if string(rs) != test.want || err != nil {
+				t.Errorf("ReadAll(UTF8Reader(%.20q))=%.20q,%v, want %.20q,nil",
+					test.str, string(rs), err, test.want)
+			}
+		}
+
+		want := test.want
+		var wantErr error
+		if test.offs >= 0 {
+			want = test.str[:test.offs]
+			wantErr = &UTF8Error{Offset: test.offs}
+		}
+		for _, src := range []io.Reader{
+			strings.NewReader(test.str),
+			iotest.OneByteReader(strings.NewReader(test.str)),
+		} {
+			r := StrictUTF8Reader(src)
+			rs, err := ReadAll(r)
+			if string(rs) != want || !reflect.DeepEqual(err, wantErr) {
+				t.Errorf("ReadAll(StrictUTF8Reader(%.20q))=%.20q,%v, want %.20q,%v",
+					test.str, string(rs), err, want, wantErr)
+			}
+			if wantErr == nil {
+				continue
+			}
+			// The error is sticky.
+			if n, err := r.Read(make([]rune, 1)); n != 0 || !reflect.DeepEqual(err, wantErr) {
+				t.Errorf("StrictUTF8Reader(%.20q).Read(1)=%d,%v, want 0,%v", test.str, n, err, wantErr)
+			}
+		}
+	}
+}
+
+func TestUTF8ReaderLen(t *testing.T) {
+	str := "a\xFF" + strings.Repeat("αβξ世界", utf8ReaderBytes/4) + "\xE4\xB8"
+	want := []rune(str)
+
+	sr := strings.NewReader("skip" + str)
+	sr.Seek(4, io.SeekStart)
+
+	for _, src := range []io.Reader{sr, bytes.NewReader([]byte(str))} {
+		r := UTF8Reader(src)
+		n, ok := readLen(r)
+		if !ok || n != int64(len(want)) {
+			t.Errorf("readLen(UTF8Reader(%T))=%d,%v, want %d,true", src, n, ok, len(want))
+			continue
+		}
+		if _, err := r.Read(make([]rune, 5)); err != nil {
+			t.Fatalf("r.Read(5)=_,%v, want _,nil", err)
+		}
+		if n, _ := readLen(r); n != int64(len(want)-5) {
+			t.Errorf("readLen(UTF8Reader(%T)) after Read(5)=%d, want %d", src, n, len(want)-5)
+		}
+
+		// Copying into a Buffer uses the Len.
+		b := NewBuffer(testBlockSize)
+		if n, err := b.ReaderFrom(0).ReadFrom(r); n != int64(len(want)-5) || err != nil {
+			t.Errorf("b.ReaderFrom(0).ReadFrom(…)=%d,%v, want %d,nil", n, err, len(want)-5)
+		}
+		if rs, err := b.Read(int(b.Size()), 0); !reflect.DeepEqual(rs, want[5:]) || err != nil {
+			t.Errorf("b.Read(…)=%.20q,%v, want %.20q,nil", string(rs), err, string(want[5:]))
+		}
+		b.Close()
+	}
+
+	if _, ok := readLen(UTF8Reader(bytes.NewBufferString(str))); ok {
+		t.Errorf("readLen(UTF8Reader(*bytes.Buffer))=_,true, want _,false")
+	}
+
+	// The size of a file can change while it is read.
+	f := tempFile(t)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString(str); err != nil {
+		t.Fatalf("f.WriteString(…)=_,%v, want _,nil", err)
+	}
+	if _, ok := readLen(UTF8Reader(f)); ok {
+		t.Errorf("readLen(UTF8Reader(*os.File))=_,true, want _,false")
+	}
+}
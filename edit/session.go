@@ -0,0 +1,350 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/eaburns/T/re1"
+)
+
+// ErrNoBuffer is returned when a command requires a current Buffer,
+// but the Session has none.
+var ErrNoBuffer = errors.New("no current buffer")
+
+// A Session is a list of Buffers, each named by its file name,
+// one of which is the current Buffer.
+type Session struct {
+	eds []*Editor
+	// Cur is the index of the current Editor,
+	// or -1 if there is none.
+	cur int
+	// Warned is the D command that was last refused
+	// because it would close dirty Buffers,
+	// if it was the most recent command.
+	warned string
+}
+
+// NewSession returns a new Session with no Buffers.
+func NewSession() *Session { return &Session{cur: -1} }
+
+// Close closes all of the Session's Buffers.
+func (s *Session) Close() error {
+	var err error
+	for _, ed := range s.eds {
+		if e := closeEditor(ed); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.eds = nil
+	s.cur = -1
+	return err
+}
+
+// Editor returns the Editor of the current Buffer,
+// or nil if there is no current Buffer.
+func (s *Session) Editor() *Editor {
+	if s.cur < 0 {
+		return nil
+	}
+	return s.eds[s.cur]
+}
+
+// Names returns the file names of the Session's Buffers
+// in the order that they were opened.
+func (s *Session) Names() []string {
+	var names []string
+	for _, ed := range s.eds {
+		names = append(names, ed.buf.FileName())
+	}
+	return names
+}
+
+func (s *Session) find(name string) int {
+	for i, ed := range s.eds {
+		if ed.buf.FileName() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Session) index(ed *Editor) int {
+	for i := range s.eds {
+		if s.eds[i] == ed {
+			return i
+		}
+	}
+	return -1
+}
+
+// Open opens a Buffer for each named file,
+// reading the file's contents if it exists,
+// and makes the last one the current Buffer.
+// Reading the file cannot be undone.
+// If a Buffer is already open for a file, it is not re-read.
+func (s *Session) Open(names ...string) error {
+	for _, name := range names {
+		if i := s.find(name); i >= 0 {
+			s.cur = i
+			continue
+		}
+		ed := NewEditor(NewBuffer())
+		err := ed.Do(LoadFile(name), nil)
+		switch {
+		case os.IsNotExist(err):
+			ed.buf.SetFileName(name)
+			err = nil
+		case err == nil:
+			// The Buffer starts out with the file's contents.
+			err = ed.buf.undo.clear()
+			ed.buf.clean = 0
+		}
+		if err != nil {
+			closeEditor(ed)
+			return err
+		}
+		s.eds = append(s.eds, ed)
+		s.cur = len(s.eds) - 1
+	}
+	return nil
+}
+
+// Switch makes the Buffer of the named file current.
+// It is an error if there is no Buffer for the file.
+func (s *Session) Switch(name string) error {
+	i := s.find(name)
+	if i < 0 {
+		return errors.New("no buffer: " + name)
+	}
+	s.cur = i
+	return nil
+}
+
+// Remove closes the Buffers of the named files
+// and removes them from the Session,
+// discarding any changes that have not been written.
+// If no names are given, the current Buffer is removed.
+// If the current Buffer is removed, there is no current Buffer.
+func (s *Session) Remove(names ...string) error {
+	if len(names) == 0 {
+		if s.cur < 0 {
+			return ErrNoBuffer
+		}
+		names = []string{s.eds[s.cur].buf.FileName()}
+	}
+	for _, name := range names {
+		i := s.find(name)
+		if i < 0 {
+			return errors.New("no buffer: " + name)
+		}
+		if err := closeEditor(s.eds[i]); err != nil {
+			return err
+		}
+		s.eds = append(s.eds[:i], s.eds[i+1:]...)
+		switch {
+		case s.cur == i:
+			s.cur = -1
+		case s.cur > i:
+			s.cur--
+		}
+	}
+	return nil
+}
+
+// Dirty returns the file names of the dirty Buffers among the named files.
+// If no names are given, the current Buffer is checked.
+func (s *Session) dirty(names ...string) []string {
+	if len(names) == 0 && s.cur >= 0 {
+		names = []string{s.eds[s.cur].buf.FileName()}
+	}
+	var dirty []string
+	for _, name := range names {
+		if i := s.find(name); i >= 0 && s.eds[i].buf.Dirty() {
+			dirty = append(dirty, name)
+		}
+	}
+	return dirty
+}
+
+// List writes a line for each Buffer to the io.Writer.
+// Each line has a ' if the Buffer is dirty,
+// a . if the Buffer is current,
+// followed by a space and the file name.
+func (s *Session) List(w io.Writer) error {
+	for i := range s.eds {
+		if err := s.listOne(i, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) listOne(i int, w io.Writer) error {
+	line := []byte("   " + s.eds[i].buf.FileName() + "\n")
+	if s.eds[i].buf.Dirty() {
+		line[0] = '\''
+	}
+	if i == s.cur {
+		line[1] = '.'
+	}
+	_, err := w.Write(line)
+	return err
+}
+
+// Each performs an Edit on each Buffer
+// whose file name matches the regular expression
+// if match is true, or does not match if match is false.
+// Each Buffer is made current while the Edit is performed,
+// and the original current Buffer is restored afterwards.
+// If the Edit is nil, the Buffers are listed as by List.
+// The regular expression is compiled with re1.Options{Delimited: true}.
+func (s *Session) Each(re string, match bool, e Edit, w io.Writer) error {
+	if len(re) == 0 {
+		re = "/"
+	}
+	rx, err := re1.Compile([]rune(withTrailingDelim(re)), re1.Options{Delimited: true})
+	if err != nil {
+		return err
+	}
+	cur := s.cur
+	defer func() { s.cur = cur }()
+	// Editing may change file names, so match them all first.
+	var eds []*Editor
+	for _, ed := range s.eds {
+		name := nameRunes(ed.buf.FileName())
+		if (rx.Match(name, 0) != nil) == match {
+			eds = append(eds, ed)
+		}
+	}
+	for _, ed := range eds {
+		i := s.index(ed)
+		if e == nil {
+			err = s.listOne(i, w)
+		} else {
+			s.cur = i
+			err = ed.Do(e, w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseEditor closes both the Editor and its Buffer.
+func closeEditor(ed *Editor) error {
+	if err := ed.Close(); err != nil {
+		ed.buf.Close()
+		return err
+	}
+	return ed.buf.Close()
+}
+
+type nameRunes []rune
+
+func (n nameRunes) Rune(i int64) rune { return n[i] }
+func (n nameRunes) Size() int64       { return int64(len(n)) }
+
+// Do parses and performs a command on the Session,
+// and returns the remaining, unparsed runes.
+//
+// The session commands are:
+//	b file
+//		Makes the Buffer of the file current,
+//		opening a Buffer for the file if there is none.
+//	B file…
+//		Opens a Buffer for each of the space-separated files,
+//		and makes the last one current.
+//	n
+//		Lists the Buffers.
+//		Each line has a ' if the Buffer is dirty,
+//		a . if the Buffer is current,
+//		followed by a space and the file name.
+//	D {file…}
+//		Closes the Buffers of the space-separated files.
+//		If no file is given, the current Buffer is closed.
+//		If any of the Buffers are dirty, an error is returned
+//		and no Buffers are closed,
+//		unless the same D command was refused immediately before;
+//		repeating the command discards the changes.
+//	X/regexp/ edit
+//		Performs the edit on each Buffer
+//		whose file name matches the regular expression.
+//		If the edit is not supplied, the matching Buffers are listed.
+//	Y/regexp/ edit
+//		Just like X, but performs the edit on each Buffer
+//		whose file name does not match the regular expression.
+// Any other command is parsed with Ed
+// and performed on the current Buffer.
+func (s *Session) Do(e []rune, w io.Writer) ([]rune, error) {
+	for len(e) > 0 && unicode.IsSpace(e[0]) && e[0] != '\n' {
+		e = e[1:]
+	}
+	warned := s.warned
+	s.warned = ""
+	if len(e) == 0 || !strings.ContainsRune("bBnDXY", e[0]) {
+		ed := s.Editor()
+		if ed == nil {
+			return e, ErrNoBuffer
+		}
+		edit, left, err := Ed(e)
+		if err != nil {
+			return left, err
+		}
+		return left, ed.Do(edit, w)
+	}
+
+	c, e := e[0], e[1:]
+	var err error
+	switch c {
+	case 'b':
+		var name string
+		name, e = parseLineArg(e)
+		err = s.Open(name)
+	case 'B':
+		var names string
+		names, e = parseLineArg(e)
+		err = s.Open(strings.Fields(names)...)
+	case 'D':
+		var names string
+		names, e = parseLineArg(e)
+		fs := strings.Fields(names)
+		cmd := strings.Join(append([]string{"D"}, fs...), " ")
+		if dirty := s.dirty(fs...); len(dirty) > 0 && cmd != warned {
+			s.warned = cmd
+			err = errors.New("changed files: " + strings.Join(dirty, " "))
+			break
+		}
+		err = s.Remove(fs...)
+	case 'n':
+		err = s.List(w)
+	case 'X', 'Y':
+		var exp []rune
+		if exp, e, err = parseRegexp(e); err != nil {
+			return e, err
+		}
+		for len(e) > 0 && unicode.IsSpace(e[0]) && e[0] != '\n' {
+			e = e[1:]
+		}
+		var edit Edit
+		if len(e) > 0 && e[0] != '\n' {
+			if edit, e, err = ed(e); err != nil {
+				return e, err
+			}
+		}
+		err = s.Each(string(exp), c == 'X', edit, w)
+	}
+	for len(e) > 0 && unicode.IsSpace(e[0]) {
+		var r rune
+		r, e = e[0], e[1:]
+		if r == '\n' {
+			break
+		}
+	}
+	return e, err
+}
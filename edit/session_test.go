@@ -0,0 +1,95 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(dir, "b.go")
+	c := filepath.Join(dir, "c.txt")
+	writeTestFile(t, a, "package a\n")
+	writeTestFile(t, b, "package b\n")
+
+	s := NewSession()
+	defer s.Close()
+	do := func(cmd, want string) {
+		out := bytes.NewBuffer(nil)
+		left, err := s.Do([]rune(cmd), out)
+		if err != nil || len(left) != 0 {
+			t.Fatalf("s.Do(%q)=%q,%v, want {},nil", cmd, left, err)
+		}
+		if out.String() != want {
+			t.Errorf("s.Do(%q) printed %q, want %q", cmd, out.String(), want)
+		}
+	}
+
+	if _, err := s.Do([]rune("p"), nil); err != ErrNoBuffer {
+		t.Errorf("s.Do(\"p\")=_,%v, want %v", err, ErrNoBuffer)
+	}
+	do("B "+a+" "+b+" "+c, "")
+	if names := s.Names(); !reflect.DeepEqual(names, []string{a, b, c}) {
+		t.Errorf("s.Names()=%q, want %q", names, []string{a, b, c})
+	}
+	do(",p", "")
+	do("a/hello\\n/", "")
+	do("n", "   "+a+"\n   "+b+"\n'. "+c+"\n")
+
+	do("b "+a, "")
+	do(",p", "package a\n")
+	do("n", " . "+a+"\n   "+b+"\n'  "+c+"\n")
+
+	do(`X/\.go$/ ,s/package/PACKAGE/`, "")
+	do(`X/\.go$/`, "'. "+a+"\n'  "+b+"\n")
+	do(`Y/\.go$/ ,p`, "hello\n")
+	do("n", "'. "+a+"\n'  "+b+"\n'  "+c+"\n")
+
+	do("b "+b, "")
+	do("w", "")
+	// Closing a dirty Buffer must be repeated.
+	if _, err := s.Do([]rune("D "+a), nil); err == nil || !strings.Contains(err.Error(), "changed files") {
+		t.Errorf("s.Do(\"D %s\")=_,%v, want changed files", a, err)
+	}
+	do("D "+a, "")
+	do("n", " . "+b+"\n'  "+c+"\n")
+	do("D", "")
+	do("n", "'  "+c+"\n")
+	if ed := s.Editor(); ed != nil {
+		t.Errorf("s.Editor()=%p, want nil", ed)
+	}
+
+	data, err := ioutil.ReadFile(b)
+	if err != nil || string(data) != "PACKAGE b\n" {
+		t.Errorf("ioutil.ReadFile(%q)=%q,%v, want %q,nil", b, data, err, "PACKAGE b\n")
+	}
+
+	// Another command in between requires repeating again.
+	do("b "+c, "")
+	if _, err := s.Do([]rune("D"), nil); err == nil {
+		t.Errorf("s.Do(\"D\")=_,nil, want error")
+	}
+	do("n", "'. "+c+"\n")
+	if _, err := s.Do([]rune("D"), nil); err == nil {
+		t.Errorf("s.Do(\"D\") after n=_,nil, want error")
+	}
+	do("D", "")
+
+	// The b command opens a file with no Buffer.
+	do("b "+a, "")
+	do("n", " . "+a+"\n")
+	do(",p", "package a\n")
+
+	// Loading the file cannot be undone.
+	do("u", "")
+	do(",p", "package a\n")
+}
@@ -0,0 +1,135 @@
+// Copyright © 2015, The T Authors.
+
+package edit
+
+// A history is a stack of groups of changes that can be reverted.
+// Each group holds the inverse of the changes made by a single transaction,
+// in the order in which the original changes were applied.
+// All entries of a group have the same sequence number.
+type history struct {
+	log *log
+	// Marks holds the marks of the Editor that made each group,
+	// from before the group was applied.
+	// There is one element per group, oldest first.
+	marks []map[rune]addr
+}
+
+func newHistory() *history { return &history{log: newLog()} }
+
+func (h *history) close() error { return h.log.close() }
+
+func (h *history) clear() error {
+	h.marks = nil
+	return h.log.clear()
+}
+
+// Push saves a copy of the marks for a new group.
+func (h *history) push(marks map[rune]addr) {
+	h.marks = append(h.marks, copyMarks(marks))
+}
+
+// Pop removes and returns the marks of the most recent group.
+func (h *history) pop() map[rune]addr {
+	l := len(h.marks)
+	if l == 0 {
+		return nil
+	}
+	marks := h.marks[l-1]
+	h.marks = h.marks[:l-1]
+	return marks
+}
+
+func copyMarks(marks map[rune]addr) map[rune]addr {
+	c := make(map[rune]addr, len(marks))
+	for r, a := range marks {
+		c[r] = a
+	}
+	return c
+}
+
+// Undo reverts the changes made by the n most recent transactions on the Buffer.
+// Dot and the marks are restored to their values before the last reverted transaction
+// if it was made by this Editor;
+// otherwise dot is set to the reverted runes.
+// If fewer than n transactions can be undone, all of them are undone.
+func (ed *Editor) Undo(n int) error { return ed.revert(n, ed.buf.undo, ed.buf.redo) }
+
+// Redo re-applies the changes reverted by the n most recent calls to Undo.
+// Redo is the inverse of Undo, and it restores dot and the marks similarly.
+// Any change made after an Undo, other than a Redo, discards the Undo.
+func (ed *Editor) Redo(n int) error { return ed.revert(n, ed.buf.redo, ed.buf.undo) }
+
+func (ed *Editor) revert(n int, src, dst *history) error {
+	ed.buf.lock.Lock()
+	defer ed.buf.lock.Unlock()
+	for i := 0; i < n; i++ {
+		switch ok, err := ed.buf.revert(ed, src, dst); {
+		case err != nil:
+			return err
+		case !ok:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Revert reverts the most recent group of changes in src,
+// recording the inverse group in dst.
+// It returns false if there was no group to revert.
+//
+// This method must be called with the Lock held.
+func (buf *Buffer) revert(ed *Editor, src, dst *history) (bool, error) {
+	last := logLast(src.log)
+	if last.end() {
+		return false, last.err
+	}
+	first := last
+	for e := last.prev(); !e.end() && e.seq == last.seq; e = e.prev() {
+		first = e
+	}
+
+	dst.push(ed.marks)
+	var dot addr
+	for e := last; ; e = e.prev() {
+		if e.err != nil {
+			return false, e.err
+		}
+		n, err := buf.change(e.at, e.data(), dst.log, buf.seq, ed.who)
+		if err != nil {
+			return false, err
+		}
+		a := addr{from: e.at.from, to: e.at.from + n}
+		if e.offs == last.offs {
+			dot = a
+		} else {
+			dot = dot.update(e.at, n)
+			if a.from < dot.from {
+				dot.from = a.from
+			}
+			if a.to > dot.to {
+				dot.to = a.to
+			}
+		}
+		if e.offs == first.offs {
+			break
+		}
+	}
+	buf.seq++
+
+	marks := src.pop()
+	if err := src.log.truncate(first); err != nil {
+		return false, err
+	}
+	buf.setDirty()
+	if buf.journal != nil {
+		if err := buf.journal.sync(); err != nil {
+			return false, err
+		}
+	}
+	if marks != nil && last.who == ed.who {
+		ed.marks = marks
+	} else {
+		ed.marks['.'] = dot
+	}
+	return true, nil
+}